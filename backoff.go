@@ -0,0 +1,63 @@
+package pool
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff factory失败重试时的等待策略
+type Backoff interface {
+	//NextInterval 回传第attempt次重试(从1开始)前要等待的时间
+	NextInterval(attempt int) time.Duration
+}
+
+// ConstantBackoff 每次重试前都等待固定的Interval
+type ConstantBackoff struct {
+	Interval time.Duration
+}
+
+// NextInterval 回传Interval
+func (b ConstantBackoff) NextInterval(attempt int) time.Duration {
+	return b.Interval
+}
+
+// ExponentialBackoff 第一次重试等待Initial，之后每次乘以Multiplier(<=1时视为2)，最多等待Max(0表示无上限)
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// NextInterval 回传Initial*Multiplier^(attempt-1)，不超过Max
+func (b ExponentialBackoff) NextInterval(attempt int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := float64(b.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		return b.Max
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// ExponentialJitterBackoff 在ExponentialBackoff算出的等待时间内随机取值，避免多个pool同时重试
+type ExponentialJitterBackoff struct {
+	ExponentialBackoff
+}
+
+// NextInterval 回传[0, ExponentialBackoff.NextInterval(attempt)]之间的随机时间
+func (b ExponentialJitterBackoff) NextInterval(attempt int) time.Duration {
+	d := b.ExponentialBackoff.NextInterval(attempt)
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
@@ -0,0 +1,84 @@
+package pool
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	b := ConstantBackoff{Interval: 5 * time.Millisecond}
+	for attempt := 1; attempt <= 3; attempt++ {
+		if d := b.NextInterval(attempt); d != 5*time.Millisecond {
+			t.Errorf("attempt %d: interval = %v, want 5ms", attempt, d)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond}
+	for i, w := range want {
+		if d := b.NextInterval(i + 1); d != w {
+			t.Errorf("attempt %d: interval = %v, want %v", i+1, d, w)
+		}
+	}
+}
+
+func TestExponentialJitterBackoff(t *testing.T) {
+	b := ExponentialJitterBackoff{ExponentialBackoff{Initial: time.Millisecond, Max: 8 * time.Millisecond}}
+	for attempt := 1; attempt <= 5; attempt++ {
+		max := b.ExponentialBackoff.NextInterval(attempt)
+		for i := 0; i < 20; i++ {
+			if d := b.NextInterval(attempt); d < 0 || d > max {
+				t.Errorf("attempt %d: interval = %v, want within [0, %v]", attempt, d, max)
+			}
+		}
+	}
+}
+
+func TestFactoryRetry(t *testing.T) {
+	var created int32
+	calls := 0
+	poolConfig := newFakeConfig(0, 1, &created)
+	factory := poolConfig.Factory
+	poolConfig.Factory = func() (interface{}, error) {
+		calls++
+		if calls <= 2 {
+			return nil, errors.New("flaky")
+		}
+		return factory()
+	}
+	poolConfig.FactoryRetries = 3
+	poolConfig.Backoff = ConstantBackoff{Interval: time.Millisecond}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	if _, err := p.Get(); err != nil {
+		t.Fatalf("Get error = %v, want nil after retries", err)
+	}
+	if calls != 3 {
+		t.Errorf("factory called %d times, want 3", calls)
+	}
+
+	calls = 0
+	poolConfig.Factory = func() (interface{}, error) {
+		calls++
+		return nil, errors.New("down")
+	}
+	poolConfig.FactoryRetries = 1
+	q, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Release()
+	if _, err := q.Get(); err == nil {
+		t.Error("Get succeeded although factory kept failing")
+	}
+	if calls != 2 {
+		t.Errorf("factory called %d times, want 2", calls)
+	}
+}
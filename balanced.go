@@ -0,0 +1,634 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BalancedPool 将多个相同的pool(例如每个后端主机一个)组合成一个Pool
+// Get从使用中连接数最少的pool取连接，Put、Close等依连接来源送回原本的pool
+// 连接需可作为map的key(例如指针)，用来记录连接的来源
+type BalancedPool struct {
+	pools []Pool
+
+	mu     sync.Mutex
+	owners map[interface{}]Pool //取出中的连接 -> 来源pool
+	health *HealthRegistry      //可疑的后端排在最后，nil表示只依负载排列
+
+	eventsOnce sync.Once
+	events     chan PoolEvent //合并各pool事件的通道
+
+	capacityOnce sync.Once
+	capacity     chan struct{} //合并各pool容量信号的通道
+
+	stopOnce sync.Once
+	done     chan struct{} //Release时关闭，通知Events、CapacityAvailable的转送协程结束
+}
+
+// NewBalancedPool 以pools建立BalancedPool，pools不可为空
+func NewBalancedPool(pools ...Pool) (*BalancedPool, error) {
+	if len(pools) == 0 {
+		return nil, ErrInvalidCapacity
+	}
+	return &BalancedPool{
+		pools:  pools,
+		owners: make(map[interface{}]Pool),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// SetHealthRegistry 设定健康状态，之后取连接时HealthRegistry认为可疑的pool(以Stats.Name对应)排在最后
+// 各pool应以相同的HealthRegistry作为Config.Health并设定不同的Name
+func (bp *BalancedPool) SetHealthRegistry(h *HealthRegistry) {
+	bp.mu.Lock()
+	bp.health = h
+	bp.mu.Unlock()
+}
+
+// byLoad 回传依使用中连接数由少到多排列的pool，设定HealthRegistry时可疑的pool排在最后
+func (bp *BalancedPool) byLoad() []Pool {
+	type load struct {
+		p       Pool
+		inUse   int
+		suspect bool
+	}
+	bp.mu.Lock()
+	health := bp.health
+	bp.mu.Unlock()
+	loads := make([]load, len(bp.pools))
+	for i, p := range bp.pools {
+		st := p.Stats()
+		loads[i] = load{p, st.InUse, health != nil && health.Suspect(st.Name)}
+	}
+	sort.SliceStable(loads, func(i, j int) bool {
+		if loads[i].suspect != loads[j].suspect {
+			return loads[j].suspect
+		}
+		return loads[i].inUse < loads[j].inUse
+	})
+	ordered := make([]Pool, len(loads))
+	for i, l := range loads {
+		ordered[i] = l.p
+	}
+	return ordered
+}
+
+// track 记录取出的连接来自p
+func (bp *BalancedPool) track(p Pool, conn interface{}, err error) (interface{}, error) {
+	if conn != nil {
+		bp.mu.Lock()
+		bp.owners[conn] = p
+		bp.mu.Unlock()
+	}
+	return conn, err
+}
+
+// owner 回传连接的来源pool，untrack为true时同时移除记录
+func (bp *BalancedPool) owner(conn interface{}, untrack bool) (Pool, error) {
+	if conn == nil {
+		return nil, ErrConnIsNil
+	}
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	p, ok := bp.owners[conn]
+	if !ok {
+		return nil, ErrUnknownConn
+	}
+	if untrack {
+		delete(bp.owners, conn)
+	}
+	return p, nil
+}
+
+// Get 从使用中连接数最少的pool取一个连接
+func (bp *BalancedPool) Get() (interface{}, error) {
+	p := bp.byLoad()[0]
+	conn, err := p.Get()
+	return bp.track(p, conn, err)
+}
+
+// GetTry 依负载由低到高向各pool尝试取得连接，都已满时回传nil，都已关闭时回传ErrPoolClosed
+func (bp *BalancedPool) GetTry() (interface{}, error) {
+	conn, _, err := bp.GetTryReason()
+	return conn, err
+}
+
+// GetTryReason 同GetTry，都未取得时回传最后一个pool的原因
+func (bp *BalancedPool) GetTryReason() (interface{}, TryReason, error) {
+	var reason TryReason
+	var err error
+	for _, p := range bp.byLoad() {
+		var conn interface{}
+		conn, reason, err = p.GetTryReason()
+		if conn != nil {
+			bp.track(p, conn, nil)
+			return conn, reason, err
+		}
+	}
+	return nil, reason, err
+}
+
+// TryN 依负载由低到高向各pool调用TryN，回传第一个取得的连接
+func (bp *BalancedPool) TryN(validate func(interface{}) bool) (interface{}, error) {
+	var err error
+	for _, p := range bp.byLoad() {
+		var conn interface{}
+		if conn, err = p.TryN(validate); conn != nil {
+			return bp.track(p, conn, nil)
+		}
+	}
+	return nil, err
+}
+
+// GetOrError 同GetTry，都已满时回传ErrPoolExhausted
+func (bp *BalancedPool) GetOrError() (interface{}, error) {
+	conn, reason, err := bp.GetTryReason()
+	if conn == nil && reason == TrySaturated {
+		return nil, ErrPoolExhausted
+	}
+	return conn, err
+}
+
+// GetWithInfo 从使用中连接数最少的pool取一个连接，并回传GetInfo
+func (bp *BalancedPool) GetWithInfo() (interface{}, GetInfo, error) {
+	p := bp.byLoad()[0]
+	conn, info, err := p.GetWithInfo()
+	bp.track(p, conn, nil)
+	return conn, info, err
+}
+
+// GetWithCancel 从使用中连接数最少的pool取一个连接，cancel被关闭则放弃
+func (bp *BalancedPool) GetWithCancel(cancel <-chan struct{}) (interface{}, error) {
+	p := bp.byLoad()[0]
+	conn, err := p.GetWithCancel(cancel)
+	return bp.track(p, conn, err)
+}
+
+// GetWithBudget 从使用中连接数最少的pool取一个连接，最多花费d的时间
+func (bp *BalancedPool) GetWithBudget(d time.Duration) (interface{}, error) {
+	p := bp.byLoad()[0]
+	conn, err := p.GetWithBudget(d)
+	return bp.track(p, conn, err)
+}
+
+// GetBatch 在timeout内每次从使用中连接数最少的pool取一个连接，最多n个，回传已取得的连接而不回传错误
+// 错误处理同channelPool的GetBatch：只有pool关闭或排空时放回已取得的连接并回传该错误
+func (bp *BalancedPool) GetBatch(n int, timeout time.Duration) ([]interface{}, error) {
+	if n <= 0 {
+		return []interface{}{}, nil
+	}
+	deadline := time.Now().Add(timeout)
+	conns := make([]interface{}, 0, n)
+	for len(conns) < n {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		conn, err := bp.GetWithBudget(remaining)
+		if err == ErrPoolClosed || err == ErrDraining {
+			bp.PutAll(conns)
+			return nil, err
+		}
+		if err != nil {
+			break
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// GetContext 从使用中连接数最少的pool取一个连接，ctx结束或超过该pool的WaitTimeout则放弃
+func (bp *BalancedPool) GetContext(ctx context.Context) (interface{}, error) {
+	p := bp.byLoad()[0]
+	conn, err := p.GetContext(ctx)
+	return bp.track(p, conn, err)
+}
+
+// WaitReady 阻塞直到任一pool有空闲连接可以取得，ctx结束时回传ctx.Err()
+func (bp *BalancedPool) WaitReady(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(bp.pools))
+	for _, p := range bp.pools {
+		go func(p Pool) { errs <- p.WaitReady(ctx) }(p)
+	}
+	var err error
+	for range bp.pools {
+		if err = <-errs; err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Put 将连接放回它的来源pool
+func (bp *BalancedPool) Put(conn interface{}) error {
+	p, err := bp.owner(conn, true)
+	if err != nil {
+		return err
+	}
+	return p.Put(conn)
+}
+
+// PutSync 将连接以PutSync放回它的来源pool
+func (bp *BalancedPool) PutSync(conn interface{}) error {
+	p, err := bp.owner(conn, true)
+	if err != nil {
+		return err
+	}
+	return p.PutSync(conn)
+}
+
+// PutFresh 将连接以PutFresh放回它的来源pool
+func (bp *BalancedPool) PutFresh(conn interface{}) error {
+	p, err := bp.owner(conn, true)
+	if err != nil {
+		return err
+	}
+	return p.PutFresh(conn)
+}
+
+// PutAll 将多个连接分别放回各自的来源pool
+func (bp *BalancedPool) PutAll(conns []interface{}) error {
+	var errs MultiError
+	for _, conn := range conns {
+		if err := bp.Put(conn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Ping 以连接的来源pool检查连接是否有效
+func (bp *BalancedPool) Ping(conn interface{}) error {
+	p, err := bp.owner(conn, false)
+	if err != nil {
+		return err
+	}
+	return p.Ping(conn)
+}
+
+// Close 以连接的来源pool关闭连接
+func (bp *BalancedPool) Close(conn interface{}) error {
+	p, err := bp.owner(conn, true)
+	if err != nil {
+		return err
+	}
+	return p.Close(conn)
+}
+
+// SetIdleTimeout 调整所有pool的连接最大空闲时间
+func (bp *BalancedPool) SetIdleTimeout(d time.Duration) {
+	for _, p := range bp.pools {
+		p.SetIdleTimeout(d)
+	}
+}
+
+// SetMaxLifetime 调整所有pool的连接最长存活时间
+func (bp *BalancedPool) SetMaxLifetime(d time.Duration) {
+	for _, p := range bp.pools {
+		p.SetMaxLifetime(d)
+	}
+}
+
+// SetStrategy 切换所有pool取得连接的策略
+func (bp *BalancedPool) SetStrategy(strategy PolicyType) {
+	for _, p := range bp.pools {
+		p.SetStrategy(strategy)
+	}
+}
+
+// SetFactory 替换所有pool建立连接的方法
+func (bp *BalancedPool) SetFactory(factory func() (interface{}, error)) error {
+	for _, p := range bp.pools {
+		if err := p.SetFactory(factory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clone 复制每个pool并组合成新的BalancedPool，overrides套用到每个pool
+func (bp *BalancedPool) Clone(overrides ...Option) (Pool, error) {
+	pools := make([]Pool, 0, len(bp.pools))
+	for _, p := range bp.pools {
+		clone, err := p.Clone(overrides...)
+		if err != nil {
+			for _, c := range pools {
+				c.Release()
+			}
+			return nil, err
+		}
+		pools = append(pools, clone)
+	}
+	return NewBalancedPool(pools...)
+}
+
+// IsClosed 所有pool都已关闭时回传true
+func (bp *BalancedPool) IsClosed() bool {
+	for _, p := range bp.pools {
+		if !p.IsClosed() {
+			return false
+		}
+	}
+	return true
+}
+
+// Stats 回传所有pool状态统计的加总，任一pool无连接数限制时MaxOpen为0
+func (bp *BalancedPool) Stats() Stats {
+	var total Stats
+	unlimited := false
+	for _, p := range bp.pools {
+		st := p.Stats()
+		if st.MaxOpen == 0 {
+			unlimited = true
+		}
+		total.MaxOpen += st.MaxOpen
+		total.NumOpen += st.NumOpen
+		total.Idle += st.Idle
+		total.Parked += st.Parked
+		total.InUse += st.InUse
+		total.WaitQueueLen += st.WaitQueueLen
+		total.Created += st.Created
+		total.Closed += st.Closed
+		total.ClosedIdle += st.ClosedIdle
+		total.ClosedLifetime += st.ClosedLifetime
+		total.EventsDropped += st.EventsDropped
+		total.WaitRejected += st.WaitRejected
+		total.BytesRead += st.BytesRead
+		total.BytesWritten += st.BytesWritten
+		total.FactoryLatency = mergeLatency(total.FactoryLatency, st.FactoryLatency)
+		total.ReuseCount = mergeReuse(total.ReuseCount, st.ReuseCount)
+		total.InUseDuration = mergeInUse(total.InUseDuration, st.InUseDuration)
+	}
+	if unlimited {
+		total.MaxOpen = 0
+	} else if total.MaxOpen > 0 {
+		total.BusyPercent = float64(total.InUse) * 100 / float64(total.MaxOpen)
+	}
+	return total
+}
+
+// StatsJSON 回传加总Stats的JSON编码
+func (bp *BalancedPool) StatsJSON() ([]byte, error) {
+	return json.Marshal(bp.Stats())
+}
+
+// ConnectionAges 回传所有pool的连接状况，依建立时间由早到晚排序
+func (bp *BalancedPool) ConnectionAges() []ConnAge {
+	var ages []ConnAge
+	for _, p := range bp.pools {
+		ages = append(ages, p.ConnectionAges()...)
+	}
+	sort.SliceStable(ages, func(i, j int) bool { return ages[i].Created.Before(ages[j].Created) })
+	return ages
+}
+
+// mergeLatency 合并两个pool的factory耗时统计
+func mergeLatency(a, b FactoryLatency) FactoryLatency {
+	if a.Count == 0 {
+		return b
+	}
+	if b.Count == 0 {
+		return a
+	}
+	m := FactoryLatency{Count: a.Count + b.Count, Min: a.Min, Max: a.Max}
+	if b.Min < m.Min {
+		m.Min = b.Min
+	}
+	if b.Max > m.Max {
+		m.Max = b.Max
+	}
+	m.Avg = time.Duration((int64(a.Avg)*a.Count + int64(b.Avg)*b.Count) / m.Count)
+	return m
+}
+
+// mergeReuse 合并两个pool的连接取出次数统计
+func mergeReuse(a, b ReuseCount) ReuseCount {
+	m := ReuseCount{Count: a.Count + b.Count, Max: a.Max}
+	if b.Max > m.Max {
+		m.Max = b.Max
+	}
+	if m.Count > 0 {
+		m.Avg = (a.Avg*float64(a.Count) + b.Avg*float64(b.Count)) / float64(m.Count)
+	}
+	return m
+}
+
+// mergeInUse 合并两个pool的连接使用时间统计
+func mergeInUse(a, b InUseDuration) InUseDuration {
+	m := InUseDuration{Count: a.Count + b.Count, Max: a.Max}
+	if b.Max > m.Max {
+		m.Max = b.Max
+	}
+	if m.Count > 0 {
+		m.Avg = time.Duration((int64(a.Avg)*a.Count + int64(b.Avg)*b.Count) / m.Count)
+	}
+	return m
+}
+
+// Warm 每次在已开启连接数最少的pool预先建立一个连接，共n个
+func (bp *BalancedPool) Warm(n int) error {
+	for i := 0; i < n; i++ {
+		p := bp.pools[0]
+		for _, q := range bp.pools[1:] {
+			if q.Stats().NumOpen < p.Stats().NumOpen {
+				p = q
+			}
+		}
+		if err := p.Warm(1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Park 在所有pool中将符合match的空闲连接移出轮替，回传移出的总数
+func (bp *BalancedPool) Park(match func(interface{}) bool) int {
+	n := 0
+	for _, p := range bp.pools {
+		n += p.Park(match)
+	}
+	return n
+}
+
+// Unpark 将所有pool被Park的连接放回轮替
+func (bp *BalancedPool) Unpark() {
+	for _, p := range bp.pools {
+		p.Unpark()
+	}
+}
+
+// GetIf 依负载由低到高向各pool调用GetIf，回传第一个符合match的空闲连接，都没有时回传nil
+func (bp *BalancedPool) GetIf(match func(interface{}) bool) (interface{}, error) {
+	var err error
+	for _, p := range bp.byLoad() {
+		var conn interface{}
+		if conn, err = p.GetIf(match); conn != nil {
+			return bp.track(p, conn, nil)
+		}
+	}
+	return nil, err
+}
+
+// Borrow 同Get
+func (bp *BalancedPool) Borrow() (interface{}, error) {
+	return bp.Get()
+}
+
+// Return 同Put
+func (bp *BalancedPool) Return(conn interface{}) error {
+	return bp.Put(conn)
+}
+
+// Invalidate 同Close
+func (bp *BalancedPool) Invalidate(conn interface{}) error {
+	return bp.Close(conn)
+}
+
+// Drain 让所有pool进入排空状态
+func (bp *BalancedPool) Drain() {
+	for _, p := range bp.pools {
+		p.Drain()
+	}
+}
+
+// CancelWaiters 取消所有pool中阻塞等待的Get，回传被取消的总数
+func (bp *BalancedPool) CancelWaiters(err error) int {
+	n := 0
+	for _, p := range bp.pools {
+		n += p.CancelWaiters(err)
+	}
+	return n
+}
+
+// Events 回传合并所有pool事件的通道，都未设定EventBuffer时回传nil
+// 第一次调用时为每个pool启动一个转送协程，合并通道满时丢弃事件，Release后转送协程结束并关闭合并通道
+func (bp *BalancedPool) Events() <-chan PoolEvent {
+	bp.eventsOnce.Do(func() {
+		var sources []<-chan PoolEvent
+		size := 0
+		for _, p := range bp.pools {
+			if ch := p.Events(); ch != nil {
+				sources = append(sources, ch)
+				size += cap(ch)
+			}
+		}
+		if len(sources) == 0 {
+			return
+		}
+		bp.events = make(chan PoolEvent, size)
+		var wg sync.WaitGroup
+		for _, ch := range sources {
+			wg.Add(1)
+			go func(ch <-chan PoolEvent) {
+				defer wg.Done()
+				for {
+					select {
+					case ev, ok := <-ch:
+						if !ok {
+							return
+						}
+						select {
+						case bp.events <- ev:
+						default:
+						}
+					case <-bp.done:
+						return
+					}
+				}
+			}(ch)
+		}
+		//所有转送协程结束后关闭合并通道
+		go func() {
+			wg.Wait()
+			close(bp.events)
+		}()
+	})
+	return bp.events
+}
+
+// CapacityAvailable 回传合并所有pool容量信号的通道，任一pool有容量时送出信号，未读取的信号会合并
+// 第一次调用时为每个pool启动一个转送协程，Release后转送协程结束并关闭合并通道
+func (bp *BalancedPool) CapacityAvailable() <-chan struct{} {
+	bp.capacityOnce.Do(func() {
+		bp.capacity = make(chan struct{}, 1)
+		var wg sync.WaitGroup
+		for _, p := range bp.pools {
+			wg.Add(1)
+			go func(ch <-chan struct{}) {
+				defer wg.Done()
+				for {
+					select {
+					case _, ok := <-ch:
+						if !ok {
+							return
+						}
+						select {
+						case bp.capacity <- struct{}{}:
+						default:
+						}
+					case <-bp.done:
+						return
+					}
+				}
+			}(p.CapacityAvailable())
+		}
+		//所有转送协程结束后关闭合并通道
+		go func() {
+			wg.Wait()
+			close(bp.capacity)
+		}()
+	})
+	return bp.capacity
+}
+
+// Maintain 依序维护所有pool，回传各项数量的加总
+func (bp *BalancedPool) Maintain() MaintainResult {
+	var total MaintainResult
+	for _, p := range bp.pools {
+		res := p.Maintain()
+		total.Pinged += res.Pinged
+		total.Removed += res.Removed
+		total.Reaped += res.Reaped
+		total.Trimmed += res.Trimmed
+		total.Expired += res.Expired
+	}
+	return total
+}
+
+// Release 释放所有pool，并结束Events、CapacityAvailable的转送协程
+func (bp *BalancedPool) Release() {
+	for _, p := range bp.pools {
+		p.Release()
+	}
+	bp.stopForwarders()
+}
+
+// stopForwarders 通知转送协程结束，可重复调用
+func (bp *BalancedPool) stopForwarders() {
+	bp.stopOnce.Do(func() { close(bp.done) })
+}
+
+// ReleaseErrors 回传所有pool的Release关闭错误
+func (bp *BalancedPool) ReleaseErrors() []error {
+	var errs []error
+	for _, p := range bp.pools {
+		errs = append(errs, p.ReleaseErrors()...)
+	}
+	return errs
+}
+
+// ReleaseKeeping 在每个pool保留n个空闲连接，d之后释放所有pool并结束转送协程
+func (bp *BalancedPool) ReleaseKeeping(n int, d time.Duration) {
+	for _, p := range bp.pools {
+		p.ReleaseKeeping(n, d)
+	}
+	time.AfterFunc(d, bp.stopForwarders)
+}
@@ -0,0 +1,91 @@
+package pool
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+var _ Pool = (*BalancedPool)(nil)
+
+func TestBalancedPool(t *testing.T) {
+	var created1, created2 int32
+	p1, err := NewPool(newFakeConfig(0, 3, &created1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := NewPool(newFakeConfig(0, 3, &created2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp, err := NewBalancedPool(p1, p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bp.Release()
+
+	var conns []interface{}
+	for i := 0; i < 3; i++ {
+		v, err := bp.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, v)
+	}
+	if in1, in2 := p1.Stats().InUse, p2.Stats().InUse; in1 != 2 || in2 != 1 {
+		t.Errorf("InUse = %d, %d, want 2, 1 (Get prefers the less-loaded pool)", in1, in2)
+	}
+
+	//conns[1]来自p2，放回后应回到p2
+	if err := bp.Put(conns[1]); err != nil {
+		t.Fatal(err)
+	}
+	if idle1, idle2 := p1.Stats().Idle, p2.Stats().Idle; idle1 != 0 || idle2 != 1 {
+		t.Errorf("Idle = %d, %d, want 0, 1 (Put returns to the originating pool)", idle1, idle2)
+	}
+	if err := bp.Put(conns[1]); err != ErrUnknownConn {
+		t.Errorf("second Put error = %v, want ErrUnknownConn", err)
+	}
+	if st := bp.Stats(); st.NumOpen != 3 || st.InUse != 2 || st.MaxOpen != 6 {
+		t.Errorf("Stats = %+v, want NumOpen 3, InUse 2, MaxOpen 6", st)
+	}
+}
+
+func TestBalancedForwardersStopOnRelease(t *testing.T) {
+	before := runtime.NumGoroutine()
+	var created1, created2 int32
+	cfg1 := newFakeConfig(0, 3, &created1)
+	cfg1.EventBuffer = 4
+	cfg2 := newFakeConfig(0, 3, &created2)
+	cfg2.EventBuffer = 4
+	p1, err := NewPool(cfg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := NewPool(cfg2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp, err := NewBalancedPool(p1, p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := bp.Events()
+	capacity := bp.CapacityAvailable()
+	if n := runtime.NumGoroutine(); n < before+4 {
+		t.Fatalf("goroutines = %d, want 4 forwarders started over %d", n, before)
+	}
+	bp.Release()
+	for range events {
+	}
+	for range capacity {
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("goroutines after Release = %d, want %d", n, before)
+	}
+}
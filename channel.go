@@ -1,44 +1,131 @@
 package pool
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math/rand"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type policyType int32
-
-const (
-	cachedOrNewConn policyType = 0 //有可用空闲连接则优先使用，没有则创建
-	alwaysNewConn   policyType = 1 //不管有没有空闲连接都重新创建
-)
-
 // channelPool 存放连接信息
 type channelPool struct {
-	factory func() (interface{}, error)
-	close   func(interface{}) error
-	ping    func(interface{}) error
+	//累计计数器以atomic操作，放在struct开头以确保64位对齐
+	numCreated       int64 //累计建立的连接数
+	numClosed        int64 //累计关闭的连接数
+	numEventsDropped int64 //事件通道满时丢弃的事件数
+	numWaitRejected  int64 //等待队列已满而被拒绝的Get数
+	numPutPending    int64 //已送入putQueue但后台协程还未放回的连接数
+
+	config     Config //建立pool时的配置，Clone时使用
+	name       string
+	factory    func() (interface{}, error)
+	close      func(interface{}) error
+	ping       func(interface{}) error
+	validate   func(interface{}) bool
+	idleLess   func(a, b interface{}) bool
+	onBorrow   func(interface{}) error //Get取出空闲连接时以该连接执行的检查
+	onNew      func(interface{}) error
+	onRelease  func(interface{}) error //Release关闭空闲连接前以该连接调用
+	onCloseErr func(interface{}, error)
+	onFirst    func(interface{}) //第一个成功建立的连接，只调用一次
+	firstOnce  sync.Once
+	retries    int     //factory失败时的重试次数
+	backoff    Backoff //factory重试前的等待策略
+
+	sync.Mutex                 //锁，操作pool时用到
+	freeConn     []*idleConn   //空闲连接
+	parked       []*idleConn   //被Park暂时移出轮替的空闲连接，不会被Get取得但仍计入numOpen
+	waitingQueue []*waiter     //阻塞请求队列，等连接数达到最大限制时，后续请求将插入此队列等待可用连接
+	numOpen      int           //已建立连接或等待建立连接数
+	closed       bool          //pool是否關閉
+	draining     bool          //pool是否在排空状态，不再取出连接，放回的连接直接关闭
+	maxIdle      int           //保留的最大空闲连接数，0表示不限制
+	maxOpen      int           //最大连接数
+	idleTimeout  time.Duration //连接最大空闲时间，超过该事件则将失效
+	validateIdle time.Duration //Get取出空闲超过此时间的连接时先ping检查
+	strategy     PolicyType
+	createWait   time.Duration  //已有连接正在建立时，新建连接前先等待其它协程放回连接的时间
+	numCreating  int            //正在调用factory建立的连接数
+	pessimistic  bool           //factory成功后才将numOpen加一
+	waitTimeout  time.Duration  //GetContext取得连接的最长时间
+	events       chan PoolEvent //事件通道，nil表示不送出事件
+	saturation   float64        //使用中连接百分比的告警门槛
+	saturated    bool           //使用中连接百分比是否已在门槛以上
+	onSaturation func(float64)
+	quarantine   func(interface{}, string) //validate或ping失败的连接交给它而不关闭
+	checkAbandon bool                      //交给已放弃等待的请求的连接放回前先以validate与ping检查
+	maxWaiters   int                       //waitingQueue的最大长度，0表示无限制
+	closeQueue   chan closing              //Get丢弃的连接送到这里由后台协程关闭，nil表示直接关闭
+	closerDone   chan struct{}             //Release时关闭，通知后台协程关闭队列中剩余的连接后结束
+
+	maxTotal    int //最多建立的连接总数，0表示无限制
+	numReserved int //已建立或正在建立的累计连接数，用来检查maxTotal
+
+	factoryLatency FactoryLatency //factory调用耗时统计，持锁更新
+	factoryTotal   time.Duration  //factory调用的总耗时，用来计算平均
+
+	onReuse    func(int)  //连接关闭时以该连接被取出的次数调用
+	reuseCount ReuseCount //已关闭连接被取出次数的统计，持锁更新
+	reuseTotal int64      //已关闭连接被取出的总次数，用来计算平均
+
+	releaseErrs []error //Release、ReleaseKeeping关闭空闲连接时的错误，持锁更新
+
+	health *HealthRegistry //建立连接成功、失败时回报，nil表示不回报
+
+	capacity chan struct{} //有连接放回空闲或空出名额时送出信号，缓冲为1以合并信号
+
+	onReturnDur   func(time.Duration) //连接放回时以取出到放回的时间调用
+	inUseDuration InUseDuration       //取出到放回时间的统计，持锁更新
+	inUseTotal    time.Duration       //取出到放回的总时间，用来计算平均
+
+	conns   map[uintptr]*connInfo  //连接地址 -> 记录，不需要记录时为nil
+	pinging map[*idleConn]struct{} //Maintain暂时移出freeConn进行ping的空闲连接，持锁更新
+
+	maxLifetime    time.Duration                    //连接从建立起的最长存活时间，0表示不限制
+	closedIdle     int64                            //超过idleTimeout而关闭的连接数，持锁更新
+	closedLifetime int64                            //超过maxLifetime而关闭的连接数，持锁更新
+	quarantined    int64                            //交给Quarantine而离开pool的连接数，持锁更新
+	onConnClose    func(time.Duration, CloseReason) //每次关闭连接后以连接存活时间及关闭原因调用
+	strictLifetime bool                             //放回超过maxLifetime的连接时回传ErrConnExpired
+	earlyRecycle   float64                          //存活超过maxLifetime的此比例后Get时依机率提早关闭
+	graceful       func(interface{}) error          //关闭仍可用的连接时使用，nil时使用close
+
+	putMu     sync.RWMutex     //保护putClosed，Put送入putQueue时持有读锁，避免Release后仍有连接送入
+	putClosed bool             //Release后不再送入putQueue
+	putQueue  chan interface{} //Put的连接送到这里由后台协程整批放回，nil表示直接放回
+	putDone   chan struct{}    //Release时关闭，通知后台协程放回队列中剩余的连接后结束
+
+	signalMu     sync.RWMutex //保护signalClosed，送出事件与容量信号时持有读锁，避免Release关闭通道后仍有送出
+	signalClosed bool         //Release后events与capacity已关闭
+}
 
-	sync.Mutex                   //锁，操作pool时用到
-	freeConn     []*idleConn     //空闲连接
-	waitingQueue []chan idleConn //阻塞请求队列，等连接数达到最大限制时，后续请求将插入此队列等待可用连接
-	numOpen      int             //已建立连接或等待建立连接数
-	closed       bool            //pool是否關閉
-	maxIdle      int             //最大空闲连接数
-	maxOpen      int             //最大连接数
-	idleTimeout time.Duration 	 //连接最大空闲时间，超过该事件则将失效
-	strategy     policyType
+// waiter waitingQueue中的一个阻塞请求
+type waiter struct {
+	req  chan idleConn
+	gone int32 //等待的协程已离开(例如panic)，Put等略过此请求，避免连接送给没有人接收的req而遗失
 }
 
 type idleConn struct {
 	conn  interface{}
 	inUse bool
 	t     time.Time
+	err   error         //CancelWaiters交给等待中请求的错误
+	ack   chan struct{} //PutSync交出的连接被等待的请求取得后关闭
 }
 
 // NewPool 初始化连接
 func NewPool(poolConfig *Config) (Pool, error) {
-	if poolConfig.InitialCap < 0 || poolConfig.MaxCap < 0 || poolConfig.InitialCap > poolConfig.MaxCap {
+	if poolConfig.InitialCap < 0 || poolConfig.MaxCap < 0 || poolConfig.MaxCap > 0 && poolConfig.InitialCap > poolConfig.MaxCap {
+		return nil, ErrInvalidCapacity
+	}
+	if poolConfig.MaxTotalCreated < 0 || poolConfig.MaxTotalCreated > 0 && poolConfig.InitialCap > poolConfig.MaxTotalCreated {
 		return nil, ErrInvalidCapacity
 	}
 	if poolConfig.Factory == nil {
@@ -49,42 +136,521 @@ func NewPool(poolConfig *Config) (Pool, error) {
 	}
 
 	cp := &channelPool{
-		factory:  poolConfig.Factory,
-		close:    poolConfig.Close,
-		ping:     nil,
-		freeConn: make([]*idleConn, 0, poolConfig.MaxCap),
-		numOpen:  0,
-		closed:   false,
-		maxIdle:  poolConfig.InitialCap,
-		maxOpen:  poolConfig.MaxCap,
-		idleTimeout: poolConfig.IdleTimeout,
-		strategy: cachedOrNewConn,
+		config:       *poolConfig,
+		name:         poolConfig.Name,
+		factory:      poolConfig.Factory,
+		close:        poolConfig.Close,
+		ping:         nil,
+		validate:     poolConfig.Validate,
+		onBorrow:     poolConfig.ValidateOnBorrow,
+		onNew:        poolConfig.OnNewConn,
+		onCloseErr:   poolConfig.OnCloseError,
+		onRelease:    poolConfig.OnRelease,
+		onConnClose:  poolConfig.OnConnClose,
+		onFirst:      poolConfig.OnFirstConnect,
+		retries:      poolConfig.FactoryRetries,
+		backoff:      poolConfig.Backoff,
+		freeConn:     make([]*idleConn, 0, poolConfig.MaxCap),
+		numOpen:      0,
+		closed:       false,
+		maxIdle:      poolConfig.MaxIdle,
+		maxOpen:      poolConfig.MaxCap,
+		idleTimeout:  poolConfig.IdleTimeout,
+		validateIdle: poolConfig.ValidateIdleThreshold,
+		strategy:     poolConfig.Strategy,
+		createWait:   poolConfig.CreateWait,
+		pessimistic:  poolConfig.PessimisticCreate,
+		waitTimeout:  poolConfig.WaitTimeout,
+		saturation:   poolConfig.SaturationThreshold,
+		onSaturation: poolConfig.OnSaturationCrossed,
+		quarantine:   poolConfig.Quarantine,
+		checkAbandon: poolConfig.ValidateAbandoned,
+		maxWaiters:   poolConfig.MaxWaiters,
+		idleLess:     poolConfig.IdleLess,
+		maxTotal:     poolConfig.MaxTotalCreated,
+		onReuse:      poolConfig.OnReuseCount,
+		onReturnDur:  poolConfig.OnReturnDuration,
+		maxLifetime:  poolConfig.MaxLifetime,
+		health:       poolConfig.Health,
+		capacity:     make(chan struct{}, 1),
+		pinging:      make(map[*idleConn]struct{}),
+	}
+
+	if poolConfig.TrackConnections || poolConfig.MaxLifetime > 0 || poolConfig.OnConnClose != nil ||
+		poolConfig.OnReuseCount != nil || poolConfig.OnReturnDuration != nil {
+		cp.conns = make(map[uintptr]*connInfo)
+	}
+	cp.strictLifetime = poolConfig.StrictLifetime
+	cp.earlyRecycle = poolConfig.EarlyRecycleFraction
+	cp.graceful = poolConfig.GracefulClose
+
+	if poolConfig.EventBuffer > 0 {
+		cp.events = make(chan PoolEvent, poolConfig.EventBuffer)
 	}
 
 	if poolConfig.Ping != nil {
 		cp.ping = poolConfig.Ping
 	}
 
+	if poolConfig.AsyncCloseQueue > 0 {
+		cp.closeQueue = make(chan closing, poolConfig.AsyncCloseQueue)
+		cp.closerDone = make(chan struct{})
+		cp.supervise("closer", cp.closer)
+	}
+
+	if poolConfig.PutCoalesceQueue > 0 {
+		cp.putQueue = make(chan interface{}, poolConfig.PutCoalesceQueue)
+		cp.putDone = make(chan struct{})
+		cp.supervise("putter", cp.putter)
+	}
+
 	for i := 0; i < poolConfig.InitialCap; i++ {
-		conn, err := cp.factory()
+		conn, err := cp.create()
 		if err != nil {
 			cp.Release()
+			if poolConfig.Name != "" {
+				return nil, fmt.Errorf("factory is not able to fill the pool %s: %s", poolConfig.Name, err)
+			}
 			return nil, fmt.Errorf("factory is not able to fill the pool: %s", err)
 		}
 		cp.freeConn = append(cp.freeConn, &idleConn{conn: conn, inUse: false, t: time.Now()})
+		cp.numOpen++
+		cp.numReserved++
 	}
-	cp.numOpen = poolConfig.InitialCap
 
 	return cp, nil
 }
 
-// Get 从pool中取一个连接
+// create 调用factory建立一个新连接，失败时依backoff重试，并执行OnNewConn，OnNewConn失败时关闭该连接并回传错误
+func (cp *channelPool) create() (interface{}, error) {
+	cp.Lock()
+	factory := cp.factory
+	cp.Unlock()
+	conn, err := cp.timedFactory(factory)
+	attempts := 1
+	for ; err != nil && attempts <= cp.retries; attempts++ {
+		if cp.backoff != nil {
+			time.Sleep(cp.backoff.NextInterval(attempts))
+		}
+		conn, err = cp.timedFactory(factory)
+	}
+	if cp.health != nil {
+		if err != nil {
+			cp.health.ReportFailure(cp.name)
+		} else {
+			cp.health.ReportSuccess(cp.name)
+		}
+	}
+	if err != nil {
+		return nil, &GetError{Attempts: attempts, Err: err}
+	}
+	atomic.AddInt64(&cp.numCreated, 1)
+	cp.emit(EventCreated, conn)
+	if cp.onNew != nil {
+		if err := cp.onNew(conn); err != nil {
+			cp.closeConn(conn, CloseReset)
+			return nil, err
+		}
+	}
+	cp.register(conn)
+	if cp.onFirst != nil {
+		cp.firstOnce.Do(func() { cp.onFirst(conn) })
+	}
+	return conn, nil
+}
+
+// timedFactory 调用factory并记录耗时，成功与失败都计入；factory回传(nil, nil)时视为失败，回传ErrFactoryReturnedNil
+func (cp *channelPool) timedFactory(factory func() (interface{}, error)) (interface{}, error) {
+	start := time.Now()
+	conn, err := factory()
+	if conn == nil && err == nil {
+		err = ErrFactoryReturnedNil
+	}
+	d := time.Since(start)
+	cp.Lock()
+	lat := &cp.factoryLatency
+	if lat.Count == 0 || d < lat.Min {
+		lat.Min = d
+	}
+	if d > lat.Max {
+		lat.Max = d
+	}
+	lat.Count++
+	cp.factoryTotal += d
+	lat.Avg = cp.factoryTotal / time.Duration(lat.Count)
+	cp.Unlock()
+	return conn, err
+}
+
+// closeConn 调用close关闭连接，并累计关闭的连接数，close失败时通知OnCloseError，最后以reason通知OnConnClose
+// reason为CloseIdle、CloseLifetime或CloseExcess且设定GracefulClose时改调用GracefulClose
+// 不论close成功与否，连接都已经从pool移除，调用者应已将numOpen减一
+func (cp *channelPool) closeConn(conn interface{}, reason CloseReason) error {
+	atomic.AddInt64(&cp.numClosed, 1)
+	cp.emit(EventClosed, conn)
+	var age time.Duration
+	if cp.onConnClose != nil {
+		age = cp.ageOf(conn)
+	}
+	cp.forget(conn)
+	closeFn := cp.close
+	if cp.graceful != nil && (reason == CloseIdle || reason == CloseLifetime || reason == CloseExcess) {
+		closeFn = cp.graceful
+	}
+	err := cp.safeClose(conn, closeFn)
+	if err != nil && cp.onCloseErr != nil {
+		cp.onCloseErr(conn, err)
+	}
+	if cp.onConnClose != nil {
+		cp.onConnClose(age, reason)
+	}
+	return err
+}
+
+// closing 待关闭的连接及关闭原因
+type closing struct {
+	conn   interface{}
+	reason CloseReason
+}
+
+// safeClose 调用closeFn，closeFn发生panic(例如对类型不符的连接做类型断言)时记录日志并回传ErrCloseFailed
+func (cp *channelPool) safeClose(conn interface{}, closeFn func(interface{}) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("pool %q: close panic: %v", cp.name, r)
+			err = ErrCloseFailed
+		}
+	}()
+	return closeFn(conn)
+}
+
+// connInfo 单一连接从建立到关闭的记录
+type connInfo struct {
+	born     time.Time //建立时间
+	uses     int       //被取出的次数
+	checkout time.Time //最近一次被取出的时间，放回后为零值
+}
+
+// connKey 回传连接在conns中的key：指针与chan类型的连接以地址识别，其它类型回传false不记录
+// 不以连接值本身作为key，例如含有slice的struct在hash时会panic
+func connKey(conn interface{}) (uintptr, bool) {
+	v := reflect.ValueOf(conn)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		return v.Pointer(), true
+	}
+	return 0, false
+}
+
+// infoLocked 回传连接的记录，没有记录时回传nil，调用者需持有锁
+func (cp *channelPool) infoLocked(conn interface{}) *connInfo {
+	if cp.conns == nil {
+		return nil
+	}
+	k, ok := connKey(conn)
+	if !ok {
+		return nil
+	}
+	return cp.conns[k]
+}
+
+// register 新建立的连接开始记录，同一地址先前遗留的记录会被取代
+func (cp *channelPool) register(conn interface{}) {
+	k, ok := connKey(conn)
+	if !ok {
+		return
+	}
+	cp.Lock()
+	if cp.conns != nil {
+		cp.conns[k] = &connInfo{born: time.Now()}
+	}
+	cp.Unlock()
+}
+
+// expiredLocked 回传连接是否已超过MaxLifetime，没有记录的连接不算超过，调用者需持有锁
+func (cp *channelPool) expiredLocked(conn interface{}) bool {
+	if cp.maxLifetime <= 0 {
+		return false
+	}
+	info := cp.infoLocked(conn)
+	return info != nil && time.Since(info.born) > cp.maxLifetime
+}
+
+// ageOf 回传连接从建立至今的时间，没有记录时回传0
+func (cp *channelPool) ageOf(conn interface{}) time.Duration {
+	cp.Lock()
+	info := cp.infoLocked(conn)
+	cp.Unlock()
+	if info == nil {
+		return 0
+	}
+	return time.Since(info.born)
+}
+
+// recycleEarlyLocked 连接存活超过earlyRecycle比例的MaxLifetime后，以随存活时间线性增加的机率回传true，
+// 让同时建立的连接不会在同一时间到期，调用者需持有锁
+func (cp *channelPool) recycleEarlyLocked(conn interface{}) bool {
+	f := cp.earlyRecycle
+	if cp.maxLifetime <= 0 || f <= 0 || f >= 1 {
+		return false
+	}
+	info := cp.infoLocked(conn)
+	if info == nil {
+		return false
+	}
+	start := time.Duration(float64(cp.maxLifetime) * f)
+	age := time.Since(info.born)
+	if age <= start {
+		return false
+	}
+	return rand.Float64() < float64(age-start)/float64(cp.maxLifetime-start)
+}
+
+// forget 连接离开pool(关闭或交给Quarantine)时移除它的记录，设定OnReuseCount时将被取出的次数计入统计并调用
+func (cp *channelPool) forget(conn interface{}) {
+	k, ok := connKey(conn)
+	if !ok {
+		return
+	}
+	cp.Lock()
+	info := cp.infoLocked(conn)
+	if info == nil {
+		cp.Unlock()
+		return
+	}
+	delete(cp.conns, k)
+	n := info.uses
+	if cp.onReuse == nil {
+		cp.Unlock()
+		return
+	}
+	rc := &cp.reuseCount
+	rc.Count++
+	cp.reuseTotal += int64(n)
+	rc.Avg = float64(cp.reuseTotal) / float64(rc.Count)
+	if n > rc.Max {
+		rc.Max = n
+	}
+	cp.Unlock()
+	cp.onReuse(n)
+}
+
+// trackAcquired 记录连接被取出：累计取出次数并记录取出时间
+func (cp *channelPool) trackAcquired(conn interface{}) {
+	cp.Lock()
+	if info := cp.infoLocked(conn); info != nil {
+		info.uses++
+		info.checkout = time.Now()
+	}
+	cp.Unlock()
+}
+
+// endCheckoutLocked 连接放回时结束它的取出记录；设定OnReturnDuration时将取出到放回的时间计入统计并回传true，
+// 由调用者在释放锁后以回传的时间调用。不是由Get取出的连接回传false，调用者需持有锁
+// 需在连接交给等待的请求之前调用，否则新的取出记录可能先被写入而被这次放回结束
+func (cp *channelPool) endCheckoutLocked(conn interface{}) (held time.Duration, ok bool) {
+	info := cp.infoLocked(conn)
+	if info == nil || info.checkout.IsZero() {
+		return 0, false
+	}
+	held = time.Since(info.checkout)
+	info.checkout = time.Time{}
+	if cp.onReturnDur == nil {
+		return 0, false
+	}
+	hd := &cp.inUseDuration
+	hd.Count++
+	cp.inUseTotal += held
+	hd.Avg = cp.inUseTotal / time.Duration(hd.Count)
+	if held > hd.Max {
+		hd.Max = held
+	}
+	return held, true
+}
+
+// Get 从pool中取一个连接，pool已关闭时回传ErrPoolClosed
 func (cp *channelPool) Get() (interface{}, error) {
-	return cp.getWithBlock(true)
+	return cp.getWithBlock(true, nil, nil)
 }
 
+// GetTry 从pool中取一个连接，连接数已达到最大限制时不阻塞，回传(nil, nil)表示稍后再试；
+// pool已关闭不是暂时的状态，同Get回传(nil, ErrPoolClosed)，与GetTryReason的TryClosed一致
 func (cp *channelPool) GetTry() (interface{}, error) {
-	return cp.getWithBlock(false)
+	conn, err := cp.getWithBlock(false, nil, nil)
+	if err == ErrPoolExhausted {
+		return nil, nil
+	}
+	return conn, err
+}
+
+// GetWithInfo 从pool中取一个连接，同Get，另外回传是否曾阻塞等待及被唤醒的原因
+func (cp *channelPool) GetWithInfo() (interface{}, GetInfo, error) {
+	var info GetInfo
+	conn, err := cp.getWithBlock(true, nil, &info)
+	if info.Waited && info.WakeReason == WakeNone {
+		switch err {
+		case nil:
+			info.WakeReason = WakeServed
+		case ErrPoolClosed:
+			info.WakeReason = WakePoolClosed
+		}
+	}
+	return conn, info, err
+}
+
+// GetWithCancel 从pool中取一个连接，阻塞等待或建立连接期间cancel被关闭则放弃，回传ErrCanceled
+func (cp *channelPool) GetWithCancel(cancel <-chan struct{}) (interface{}, error) {
+	return cp.getWithBlock(true, cancel, nil)
+}
+
+// GetWithBudget 从pool中取一个连接，等待空闲连接与建立新连接共用d的时间，超过则回传ErrBudgetExceeded
+func (cp *channelPool) GetWithBudget(d time.Duration) (interface{}, error) {
+	budget := make(chan struct{})
+	timer := time.AfterFunc(d, func() { close(budget) })
+	defer timer.Stop()
+	conn, err := cp.getWithBlock(true, budget, nil)
+	if err == ErrCanceled {
+		return nil, ErrBudgetExceeded
+	}
+	return conn, err
+}
+
+// GetBatch 在timeout内取得最多n个连接，回传已取得的连接(可能少于n个或为空)而不回传错误，
+// 时间到或遇到建立连接失败、ErrQuotaExhausted、ErrTooManyWaiters等错误时即停止；
+// 只有pool关闭或进入排空状态时放回已取得的连接并回传该错误。n<=0时回传空的结果
+func (cp *channelPool) GetBatch(n int, timeout time.Duration) ([]interface{}, error) {
+	if n <= 0 {
+		return []interface{}{}, nil
+	}
+	expired := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(expired) })
+	defer timer.Stop()
+	conns := make([]interface{}, 0, n)
+	for len(conns) < n {
+		conn, err := cp.getWithBlock(true, expired, nil)
+		if err == ErrPoolClosed || err == ErrDraining {
+			cp.PutAll(conns)
+			return nil, err
+		}
+		if err != nil {
+			break
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// GetContext 从pool中取一个连接，ctx结束或超过WaitTimeout则放弃
+// 因WaitTimeout放弃时回传ErrWaitTimeout，因ctx放弃时回传ctx.Err()
+func (cp *channelPool) GetContext(ctx context.Context) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	waitCtx := ctx
+	if cp.waitTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, cp.waitTimeout)
+		defer cancel()
+	}
+	conn, err := cp.getWithBlock(true, waitCtx.Done(), nil)
+	if err == ErrCanceled {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, ErrWaitTimeout
+	}
+	return conn, err
+}
+
+// waitReadyBackoff WaitReady建立连接失败或连接数已满时，重新检查前的等待策略
+var waitReadyBackoff Backoff = ExponentialBackoff{Initial: 10 * time.Millisecond, Max: time.Second, Multiplier: 2}
+
+// WaitReady 阻塞直到pool至少有一个空闲连接可以取得，不会取出连接，ctx结束时回传ctx.Err()
+// 没有空闲连接且未达MaxCap时建立一个连接放入pool，失败则稍后重试，适合用在启动流程中确认后端可用
+// 设定PutCoalesceQueue时，已放回但后台协程还未处理完的连接也算空闲连接；自己建立的连接直接放入freeConn，不经过队列
+func (cp *channelPool) WaitReady(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		cp.Lock()
+		if cp.closed {
+			cp.Unlock()
+			return ErrPoolClosed
+		}
+		if cp.draining {
+			cp.Unlock()
+			return ErrDraining
+		}
+		if len(cp.freeConn) > 0 || atomic.LoadInt64(&cp.numPutPending) > 0 {
+			cp.Unlock()
+			return nil
+		}
+		if (cp.maxOpen <= 0 || cp.numOpen < cp.maxOpen) && !cp.quotaExhaustedLocked() {
+			cp.beginCreateLocked()
+			cp.Unlock()
+			conn, err := cp.createWithCancel(ctx.Done())
+			if err == nil {
+				//PutAll不经过putQueue，下一轮检查时连接已在freeConn中
+				cp.PutAll([]interface{}{conn})
+				continue
+			}
+		} else {
+			cp.Unlock()
+		}
+		timer := time.NewTimer(waitReadyBackoff.NextInterval(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// GetTryReason 同GetTry，另外回传结果的原因，让调用者区分连接数已满、pool关闭等情况
+func (cp *channelPool) GetTryReason() (interface{}, TryReason, error) {
+	conn, err := cp.getWithBlock(false, nil, nil)
+	switch err {
+	case nil:
+		return conn, TryAvailable, nil
+	case ErrPoolExhausted:
+		return nil, TrySaturated, nil
+	case ErrPoolClosed:
+		return nil, TryClosed, err
+	case ErrDraining:
+		return nil, TryDraining, err
+	default:
+		return nil, TryFailed, err
+	}
+}
+
+// TryN 不阻塞地取得一个通过validate检查的连接，最多检查目前所有的空闲连接，未通过的连接会被关闭
+// 都未通过时同GetTry，连接数未达上限则建立新连接，否则回传nil
+func (cp *channelPool) TryN(validate func(interface{}) bool) (interface{}, error) {
+	cp.Lock()
+	for n := len(cp.freeConn); n > 0 && !cp.closed; n-- {
+		conn, dropped := cp.takeFreeLocked()
+		if conn == nil {
+			if dropped {
+				continue
+			}
+			break
+		}
+		cp.Unlock()
+		if validate(conn) {
+			cp.emit(EventAcquired, conn)
+			cp.trackAcquired(conn)
+			cp.checkSaturation()
+			return conn, nil
+		}
+		cp.closeAs(conn, CloseReset)
+		cp.Lock()
+	}
+	cp.Unlock()
+	return cp.GetTry()
+}
+
+// GetOrError 从pool中取一个连接，连接数已达到最大限制时不阻塞，回传ErrPoolExhausted
+func (cp *channelPool) GetOrError() (interface{}, error) {
+	return cp.getWithBlock(false, nil, nil)
 }
 
 // Put 将连接放回pool中
@@ -93,133 +659,1325 @@ func (cp *channelPool) Put(conn interface{}) error {
 	if conn == nil {
 		return ErrConnIsNil
 	}
-	if cp.closed {
-		cp.close(conn)
-		return ErrPoolClosedAndClose
+
+	//设定PutCoalesceQueue时交给后台协程整批放回，Release之后则直接处理
+	if cp.putQueue != nil {
+		cp.putMu.RLock()
+		if !cp.putClosed {
+			atomic.AddInt64(&cp.numPutPending, 1)
+			cp.putQueue <- conn
+			cp.putMu.RUnlock()
+			return nil
+		}
+		cp.putMu.RUnlock()
 	}
 
 	cp.Lock()
-	if cp.maxOpen > 0 && cp.numOpen > cp.maxOpen {
+	if cp.closed {
+		cp.numOpen--
 		cp.Unlock()
-		return ErrOpenNumber
+		cp.closeConn(conn, CloseRelease)
+		return ErrPoolClosedAndClose
 	}
-	//有等待连接的请求则将连接发给它们，否则放入freeConn
-	if c := len(cp.waitingQueue); c > 0 {
-		req := cp.waitingQueue[0]
-		// This copy is O(n) but in practice faster than a linked list.
-		// TODO: consider compacting it down less often and
-		// moving the base instead?
-		copy(cp.waitingQueue, cp.waitingQueue[1:])
-		cp.waitingQueue = cp.waitingQueue[:c-1]
-		req <- idleConn{conn: conn, inUse: true, t: time.Now()}
-	} else {
-		cp.freeConn = append(cp.freeConn, &idleConn{conn: conn, inUse: false, t: time.Now()})
+	if cp.draining {
+		cp.numOpen--
+		cp.Unlock()
+		cp.closeConn(conn, CloseRelease)
+		return ErrDrainingCloseOnReturn
 	}
+	res := cp.putLocked(conn)
 	cp.Unlock()
+	if res.drop {
+		cp.closeConn(conn, res.reason)
+		if res.timed {
+			cp.onReturnDur(res.held)
+		}
+		if res.reason == CloseLifetime && cp.strictLifetime {
+			return ErrConnExpired
+		}
+		return nil
+	}
+	cp.emit(EventReturned, conn)
+	if res.timed {
+		cp.onReturnDur(res.held)
+	}
+	cp.checkSaturation()
 	return nil
 }
 
-// Ping 检查单条连接是否有效
-func (cp *channelPool) Ping(conn interface{}) error {
+// PutSync 同Put，但有等待的请求时会阻塞到该请求实际取得连接才回传
+// 等待的请求在交接时放弃，连接改放回pool后也会回传
+func (cp *channelPool) PutSync(conn interface{}) error {
 	if conn == nil {
 		return ErrConnIsNil
 	}
-	if cp.ping == nil {
-		return ErrInvalidPingFunc
+	cp.Lock()
+	if !cp.closed && !cp.draining && (cp.maxOpen <= 0 || cp.numOpen <= cp.maxOpen) && !cp.expiredLocked(conn) {
+		if req := cp.popWaiterLocked(); req != nil {
+			held, timed := cp.endCheckoutLocked(conn)
+			ack := make(chan struct{})
+			req <- idleConn{conn: conn, inUse: true, t: time.Now(), ack: ack}
+			cp.Unlock()
+			cp.emit(EventReturned, conn)
+			if timed {
+				cp.onReturnDur(held)
+			}
+			<-ack
+			return nil
+		}
 	}
-	return cp.ping(conn)
+	cp.Unlock()
+	return cp.Put(conn)
 }
 
-// Close 關閉一條連線，並將已開啟連線數減一
-// 如果pool已經關閉，會把連線關閉，回傳ErrPoolClosedAndClose
-func (cp *channelPool) Close(conn interface{}) error {
+// PutFresh 同Put，并将连接的建立时间重设为现在，适用于刚重新认证或重置过、等同新建的连接
+// 放回时以新的建立时间检查MaxLifetime，之后的MaxLifetime也从现在起算；没有记录建立时间的连接同Put
+func (cp *channelPool) PutFresh(conn interface{}) error {
 	if conn == nil {
 		return ErrConnIsNil
 	}
-	if cp.closed {
-		cp.close(conn)
-		return ErrPoolClosedAndClose
-	}
-
-	cp.Lock()
-	cp.numOpen--
-	cp.Unlock()
-	return cp.close(conn)
-}
-
-// Release 释放连接池中所有连接
-func (cp *channelPool) Release() {
 	cp.Lock()
-	cp.closed = true
-	cp.Unlock()
-
-	for _, wrapConn := range cp.freeConn {
-		cp.close((*wrapConn).conn)
+	if info := cp.infoLocked(conn); info != nil {
+		info.born = time.Now()
 	}
+	cp.Unlock()
+	return cp.Put(conn)
 }
 
-func (cp *channelPool) getWithBlock(block bool) (interface{}, error) {
+// PutAll 将多个连接一次放回pool中，整批只取一次锁
+// 个别连接放回失败不影响其他连接，所有错误合并成MultiError回传
+func (cp *channelPool) PutAll(conns []interface{}) error {
 	cp.Lock()
-	if cp.closed {
+	if cp.closed || cp.draining {
+		closed := cp.closed
+		for _, conn := range conns {
+			if conn != nil {
+				cp.numOpen--
+			}
+		}
 		cp.Unlock()
-		return nil, ErrPoolClosed
+		var pc panicCarry
+		for _, conn := range conns {
+			if conn != nil {
+				pc.run(func() { cp.closeConn(conn, CloseRelease) })
+			}
+		}
+		pc.repanic()
+		if closed {
+			return ErrPoolClosedAndClose
+		}
+		return ErrDrainingCloseOnReturn
 	}
 
-	//从freeConn取一个空闲连接
-	numFree := len(cp.freeConn)
-	if cp.strategy == cachedOrNewConn && numFree > 0 {
-		conn := cp.freeConn[0]
-		copy(cp.freeConn, cp.freeConn[1:])
-		cp.freeConn = cp.freeConn[:numFree-1]
-		//判断是否超时，超时则丢弃
-		if timeout := cp.idleTimeout; timeout > 0 {
-			if conn.t.Add(timeout).Before(time.Now()) {
-				//丢弃并关闭该连接
-				cp.close(conn.conn)
-				subconn, err := cp.factory()
-				if err != nil {
-					cp.Unlock()
-					return nil, err
-				}
-				ic := &idleConn{conn: subconn, inUse: true, t: time.Now()}
-				cp.Unlock()
-				return ic.conn, nil
+	var errs MultiError
+	var held []time.Duration //放回的连接从取出到放回的时间，释放锁后调用OnReturnDuration
+	var drop []closing       //超过maxOpen、MaxLifetime或MaxIdle而要关闭的连接
+	for _, conn := range conns {
+		if conn == nil {
+			errs = append(errs, ErrConnIsNil)
+			continue
+		}
+		res := cp.putLocked(conn)
+		if res.timed {
+			held = append(held, res.held)
+		}
+		if res.drop {
+			drop = append(drop, closing{conn, res.reason})
+			if res.reason == CloseLifetime && cp.strictLifetime {
+				errs = append(errs, ErrConnExpired)
 			}
+			continue
 		}
-		conn.inUse = true
-		cp.Unlock()
-		return conn.conn, nil
+		cp.emit(EventReturned, conn)
+	}
+	cp.Unlock()
+	//回调panic时仍处理完其余连接，避免后台putter整批放回时遗漏未关闭的连接
+	var pc panicCarry
+	for _, c := range drop {
+		pc.run(func() { cp.closeConn(c.conn, c.reason) })
+	}
+	for _, d := range held {
+		pc.run(func() { cp.onReturnDur(d) })
 	}
+	pc.repanic()
+	cp.checkSaturation()
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
 
-	//如果没有空闲连接，而且当前建立的连接数已经达到最大限制则将请求加入waitingQueue队列，
-	//并阻塞在这里，直到其它协程将占用的连接释放或connectionOpenner创建
-	if cp.maxOpen > 0 && cp.numOpen >= cp.maxOpen {
-		if !block {
-			cp.Unlock()
-			return nil, nil
-		}
-		// Make the connRequest channel. It's buffered so that the
-		// connectionOpener doesn't block while waiting for the req to be read.
-		req := make(chan idleConn, 1)
-		cp.waitingQueue = append(cp.waitingQueue, req)
-		cp.Unlock()
-		ret, ok := <-req //阻塞
-		if !ok {
-			return nil, ErrPoolClosed
+// panicCarry 依序执行多个调用，其中的panic先记下让其余调用照常执行，最后由repanic以第一个panic值再次panic
+type panicCarry struct {
+	panicked bool
+	value    interface{}
+}
+
+// run 执行fn，fn发生panic时记下第一个panic值
+func (pc *panicCarry) run(fn func()) {
+	defer func() {
+		if r := recover(); r != nil && !pc.panicked {
+			pc.panicked, pc.value = true, r
 		}
-		ret.inUse = true
-		return ret.conn, nil
+	}()
+	fn()
+}
+
+// repanic 有记下的panic时以它再次panic
+func (pc *panicCarry) repanic() {
+	if pc.panicked {
+		panic(pc.value)
 	}
+}
 
-	cp.numOpen++ //上面说了numOpen是已经建立或即将建立连接数，这里还没有建立连接，只是乐观的认为后面会成功，失败的时候再将此值减1
-	cp.Unlock()
-	conn, err := cp.factory()
-	if err != nil {
-		cp.Lock()
+// putResult putLocked的结果
+type putResult struct {
+	drop   bool          //连接未放回，由调用者释放锁后以reason关闭
+	reason CloseReason   //drop时的关闭原因
+	held   time.Duration //连接从取出到放回的时间，timed为true时有效
+	timed  bool          //调用者释放锁后需以held调用OnReturnDuration
+}
+
+// putLocked 将连接交给等待的请求或放入freeConn，调用者需持有锁
+// 已开启连接数超过maxOpen、连接已超过MaxLifetime或空闲连接已达MaxIdle时不放回，将numOpen减一并回传drop，由调用者释放锁后关闭该连接
+func (cp *channelPool) putLocked(conn interface{}) putResult {
+	if cp.maxOpen > 0 && cp.numOpen > cp.maxOpen {
 		cp.numOpen--
-		cp.Unlock()
-		return nil, err
+		return putResult{drop: true, reason: CloseExcess}
+	}
+	if cp.expiredLocked(conn) {
+		cp.closedLifetime++
+		cp.releaseSlotLocked()
+		return putResult{drop: true, reason: CloseLifetime}
+	}
+	var res putResult
+	res.held, res.timed = cp.endCheckoutLocked(conn)
+	//有等待连接的请求则将连接发给它们，否则放入freeConn
+	//req的缓冲为1，且从waitingQueue取出后只会被送一次，所以持有锁送出时不会阻塞
+	if req := cp.popWaiterLocked(); req != nil {
+		req <- idleConn{conn: conn, inUse: true, t: time.Now()}
+	} else if cp.maxIdle > 0 && len(cp.freeConn) >= cp.maxIdle {
+		//空闲连接已达MaxIdle，不再放回
+		cp.releaseSlotLocked()
+		res.drop, res.reason = true, CloseExcess
+	} else {
+		cp.freeConn = append(cp.freeConn, &idleConn{conn: conn, inUse: false, t: time.Now()})
+		cp.signalCapacity()
+	}
+	return res
+}
+
+// popWaiterLocked 取出waitingQueue中最早仍在等待的请求，已离开的请求直接移除，没有则回传nil，调用者需持有锁
+func (cp *channelPool) popWaiterLocked() chan idleConn {
+	for {
+		c := len(cp.waitingQueue)
+		if c == 0 {
+			return nil
+		}
+		w := cp.waitingQueue[0]
+		// This copy is O(n) but in practice faster than a linked list.
+		// TODO: consider compacting it down less often and
+		// moving the base instead?
+		copy(cp.waitingQueue, cp.waitingQueue[1:])
+		//清除最后一格的引用，让离开的请求及其通道可以被回收
+		cp.waitingQueue[c-1] = nil
+		cp.waitingQueue = cp.waitingQueue[:c-1]
+		if atomic.LoadInt32(&w.gone) == 0 {
+			return w.req
+		}
+	}
+}
+
+// Borrow 同Get，提供Apache Commons Pool/HikariCP习惯的命名
+func (cp *channelPool) Borrow() (interface{}, error) {
+	return cp.Get()
+}
+
+// Return 同Put
+func (cp *channelPool) Return(conn interface{}) error {
+	return cp.Put(conn)
+}
+
+// Invalidate 同Close
+func (cp *channelPool) Invalidate(conn interface{}) error {
+	return cp.Close(conn)
+}
+
+// Ping 检查单条连接是否有效
+func (cp *channelPool) Ping(conn interface{}) error {
+	if conn == nil {
+		return ErrConnIsNil
+	}
+	if cp.ping == nil {
+		return ErrInvalidPingFunc
+	}
+	return cp.ping(conn)
+}
+
+// Close 關閉一條連線，並將已開啟連線數減一
+// 如果pool已經關閉，會把連線關閉，回傳ErrPoolClosedAndClose
+func (cp *channelPool) Close(conn interface{}) error {
+	if conn == nil {
+		return ErrConnIsNil
+	}
+	return cp.closeAs(conn, CloseUser)
+}
+
+// closeAs 同Close，以reason通知OnConnClose，供pool自行关闭已取出的连接时使用
+func (cp *channelPool) closeAs(conn interface{}, reason CloseReason) error {
+	cp.Lock()
+	if cp.closed {
+		cp.numOpen--
+		cp.Unlock()
+		cp.closeConn(conn, reason)
+		return ErrPoolClosedAndClose
+	}
+	cp.releaseSlotLocked()
+	cp.Unlock()
+	cp.checkSaturation()
+	return cp.closeConn(conn, reason)
+}
+
+// releaseSlotLocked 将已开启连接数减一，并唤醒一个等待的请求让它自己建立连接，调用者需持有锁
+func (cp *channelPool) releaseSlotLocked() {
+	cp.numOpen--
+	if req := cp.popWaiterLocked(); req != nil {
+		req <- idleConn{}
+	} else {
+		cp.signalCapacity()
+	}
+}
+
+// CapacityAvailable 回传容量信号通道，连接放回空闲或空出可建立连接的名额且没有被等待的请求取走时送出信号
+// 通道缓冲为1，未读取的信号会合并为一个；收到信号只表示当时有容量，之后的Get仍可能需要等待。Release后通道被关闭
+func (cp *channelPool) CapacityAvailable() <-chan struct{} {
+	return cp.capacity
+}
+
+// signalCapacity 送出容量信号，已有未读取的信号时略过，可在持有锁时调用
+func (cp *channelPool) signalCapacity() {
+	cp.signalMu.RLock()
+	if !cp.signalClosed {
+		select {
+		case cp.capacity <- struct{}{}:
+		default:
+		}
+	}
+	cp.signalMu.RUnlock()
+}
+
+// closeSignals 关闭events与capacity通道，等待进行中的送出结束，之后的事件与信号都不再送出
+func (cp *channelPool) closeSignals() {
+	cp.signalMu.Lock()
+	if !cp.signalClosed {
+		cp.signalClosed = true
+		if cp.events != nil {
+			close(cp.events)
+		}
+		close(cp.capacity)
+	}
+	cp.signalMu.Unlock()
+}
+
+// SetIdleTimeout 调整连接最大空闲时间，之后的Get都依新值检查，0表示不检查
+func (cp *channelPool) SetIdleTimeout(d time.Duration) {
+	cp.Lock()
+	cp.idleTimeout = d
+	cp.Unlock()
+}
+
+// SetMaxLifetime 调整连接从建立起的最长存活时间，之后的Get、Put与Maintain都依新值检查，0表示不限制
+// 原本不需要记录的pool从此时开始记录，之前建立的连接没有建立时间，不受此限制
+func (cp *channelPool) SetMaxLifetime(d time.Duration) {
+	cp.Lock()
+	cp.maxLifetime = d
+	if d > 0 && cp.conns == nil {
+		cp.conns = make(map[uintptr]*connInfo)
+	}
+	cp.Unlock()
+}
+
+// SetStrategy 切换取得连接的策略，之后的Get都依新策略，例如怀疑连接异常时暂时改用AlwaysNewConn
+func (cp *channelPool) SetStrategy(strategy PolicyType) {
+	cp.Lock()
+	cp.strategy = strategy
+	cp.Unlock()
+}
+
+// SetFactory 替换建立连接的方法，例如轮换凭证，之后建立的连接都使用新的factory，已有的连接不受影响
+func (cp *channelPool) SetFactory(factory func() (interface{}, error)) error {
+	if factory == nil {
+		return ErrInvalidFactoryFunc
+	}
+	cp.Lock()
+	cp.factory = factory
+	cp.Unlock()
+	return nil
+}
+
+// Clone 以相同的配置建立一个新的pool，overrides依序修改配置，新pool与原本的pool不共用任何状态
+// Factory、IdleTimeout、MaxLifetime与Strategy使用目前的值，包含SetFactory、SetIdleTimeout、SetMaxLifetime与SetStrategy的修改
+func (cp *channelPool) Clone(overrides ...Option) (Pool, error) {
+	config := cp.config
+	cp.Lock()
+	config.Factory = cp.factory
+	config.IdleTimeout = cp.idleTimeout
+	config.MaxLifetime = cp.maxLifetime
+	config.Strategy = cp.strategy
+	cp.Unlock()
+	for _, override := range overrides {
+		override(&config)
+	}
+	return NewPool(&config)
+}
+
+// IsClosed 回传pool是否已经关闭
+func (cp *channelPool) IsClosed() bool {
+	cp.Lock()
+	defer cp.Unlock()
+	return cp.closed
+}
+
+// Warm 预先建立最多n个新连接放入pool，不超过最大连接数
+// 依序建立，第一次失败即停止并回传错误，之前已建立的连接保留在pool中
+func (cp *channelPool) Warm(n int) error {
+	for i := 0; i < n; i++ {
+		cp.Lock()
+		if cp.closed {
+			cp.Unlock()
+			return ErrPoolClosed
+		}
+		if cp.draining {
+			cp.Unlock()
+			return ErrDraining
+		}
+		if cp.maxOpen > 0 && cp.numOpen >= cp.maxOpen || cp.quotaExhaustedLocked() {
+			cp.Unlock()
+			return nil
+		}
+		cp.beginCreateLocked()
+		cp.Unlock()
+		conn, err := cp.createWithCancel(nil)
+		if err == errCreateOverflow {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := cp.Put(conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Park 将符合match的空闲连接暂时移出轮替，不关闭连接，回传移出的连接数
+// 被移出的连接不会被Get取得，但仍计入已开启连接数，直到Unpark或Release
+func (cp *channelPool) Park(match func(interface{}) bool) int {
+	cp.Lock()
+	candidates := make([]*idleConn, len(cp.freeConn))
+	copy(candidates, cp.freeConn)
+	cp.Unlock()
+
+	//match为用户的方法，不持有锁调用
+	matched := make(map[*idleConn]bool)
+	for _, ic := range candidates {
+		if match(ic.conn) {
+			matched[ic] = true
+		}
+	}
+
+	cp.Lock()
+	defer cp.Unlock()
+	if cp.closed {
+		return 0
+	}
+	n := 0
+	kept := cp.freeConn[:0]
+	for _, ic := range cp.freeConn {
+		//检查期间已被Get取走的连接不在freeConn中，自然略过
+		if matched[ic] {
+			cp.parked = append(cp.parked, ic)
+			n++
+		} else {
+			kept = append(kept, ic)
+		}
+	}
+	cp.freeConn = kept
+	return n
+}
+
+// GetIf 不阻塞、不建立新连接地取出一个符合match的空闲连接，没有符合的连接时回传nil
+// 取出的连接与Get一样经过IdleTimeout、Validate、ping与ValidateOnBorrow检查，未通过的连接被关闭(或交给Quarantine)并改取下一个符合的连接
+func (cp *channelPool) GetIf(match func(interface{}) bool) (interface{}, error) {
+	cp.Lock()
+	if cp.closed {
+		cp.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if cp.draining {
+		cp.Unlock()
+		return nil, ErrDraining
+	}
+	candidates := make([]*idleConn, len(cp.freeConn))
+	copy(candidates, cp.freeConn)
+	cp.Unlock()
+
+	//match为用户的方法，不持有锁调用
+	matched := make(map[*idleConn]bool)
+	for _, ic := range candidates {
+		if match(ic.conn) {
+			matched[ic] = true
+		}
+	}
+	if len(matched) == 0 {
+		return nil, nil
+	}
+
+	cp.Lock()
+	for {
+		if cp.closed {
+			cp.Unlock()
+			return nil, ErrPoolClosed
+		}
+		//检查期间已被Get取走的连接不在freeConn中，自然略过
+		idx := -1
+		for i, ic := range cp.freeConn {
+			if matched[ic] {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			cp.Unlock()
+			return nil, nil
+		}
+		ic := cp.freeConn[idx]
+		delete(matched, ic)
+		copy(cp.freeConn[idx:], cp.freeConn[idx+1:])
+		cp.freeConn = cp.freeConn[:len(cp.freeConn)-1]
+		if conn, _ := cp.checkoutLocked(ic); conn != nil {
+			cp.Unlock()
+			cp.emit(EventAcquired, conn)
+			cp.trackAcquired(conn)
+			cp.checkSaturation()
+			return conn, nil
+		}
+	}
+}
+
+// Unpark 将所有被Park的连接放回轮替，有等待的请求则直接交给它们
+func (cp *channelPool) Unpark() {
+	cp.Lock()
+	parked := cp.parked
+	cp.parked = nil
+	var drop []closing
+	for _, ic := range parked {
+		if res := cp.putLocked(ic.conn); res.drop {
+			drop = append(drop, closing{ic.conn, res.reason})
+		}
+	}
+	cp.Unlock()
+	for _, c := range drop {
+		cp.closeConn(c.conn, c.reason)
+	}
+}
+
+// Stats 回传pool目前的状态统计
+func (cp *channelPool) Stats() Stats {
+	cp.Lock()
+	defer cp.Unlock()
+	return Stats{
+		Name:           cp.name,
+		MaxOpen:        cp.maxOpen,
+		NumOpen:        cp.numOpen,
+		Idle:           len(cp.freeConn),
+		Parked:         len(cp.parked),
+		InUse:          cp.numOpen - len(cp.freeConn) - len(cp.parked),
+		BusyPercent:    cp.busyPercentLocked(),
+		WaitQueueLen:   len(cp.waitingQueue),
+		Created:        atomic.LoadInt64(&cp.numCreated),
+		Closed:         atomic.LoadInt64(&cp.numClosed),
+		ClosedIdle:     cp.closedIdle,
+		ClosedLifetime: cp.closedLifetime,
+		Quarantined:    cp.quarantined,
+		EventsDropped:  atomic.LoadInt64(&cp.numEventsDropped),
+		WaitRejected:   atomic.LoadInt64(&cp.numWaitRejected),
+		FactoryLatency: cp.factoryLatency,
+		ReuseCount:     cp.reuseCount,
+		InUseDuration:  cp.inUseDuration,
+	}
+}
+
+// busyPercentLocked 回传使用中连接数占最大连接数的百分比，无连接数限制时为0，调用者需持有锁
+func (cp *channelPool) busyPercentLocked() float64 {
+	if cp.maxOpen <= 0 {
+		return 0
+	}
+	return float64(cp.numOpen-len(cp.freeConn)-len(cp.parked)) * 100 / float64(cp.maxOpen)
+}
+
+// checkSaturation 使用中连接百分比向上越过SaturationThreshold时调用OnSaturationCrossed，
+// 降回门槛以下后才会再次调用
+func (cp *channelPool) checkSaturation() {
+	if cp.onSaturation == nil || cp.maxOpen <= 0 {
+		return
+	}
+	cp.Lock()
+	pct := cp.busyPercentLocked()
+	crossed := pct >= cp.saturation && !cp.saturated
+	cp.saturated = pct >= cp.saturation
+	cp.Unlock()
+	if crossed {
+		cp.onSaturation(pct)
+	}
+}
+
+// StatsJSON 回传Stats的JSON编码，方便直接用于除错用的HTTP端点
+func (cp *channelPool) StatsJSON() ([]byte, error) {
+	return json.Marshal(cp.Stats())
+}
+
+// ConnectionAges 回传pool中各连接的建立时间与闲置情况，依建立时间由早到晚排序
+// 空闲(含Maintain正在ping的)与被Park的连接都会列出；使用中的连接要有记录才知道，见Config.TrackConnections
+// 持锁时只复制记录，计算在释放锁后进行
+func (cp *channelPool) ConnectionAges() []ConnAge {
+	type snapshot struct {
+		created time.Time
+		idle    idleConn
+		parked  bool
+	}
+	cp.Lock()
+	var idle []snapshot
+	add := func(ic *idleConn, parked bool) {
+		sn := snapshot{idle: *ic, parked: parked}
+		if info := cp.infoLocked(ic.conn); info != nil {
+			sn.created = info.born
+		}
+		idle = append(idle, sn)
+	}
+	for _, ic := range cp.freeConn {
+		add(ic, false)
+	}
+	for ic := range cp.pinging {
+		add(ic, false)
+	}
+	for _, ic := range cp.parked {
+		add(ic, true)
+	}
+	var inUse []time.Time
+	for _, info := range cp.conns {
+		if !info.checkout.IsZero() {
+			inUse = append(inUse, info.born)
+		}
+	}
+	cp.Unlock()
+
+	now := time.Now()
+	ages := make([]ConnAge, 0, len(idle)+len(inUse))
+	for _, sn := range idle {
+		ages = append(ages, ConnAge{Created: sn.created, LastUsed: sn.idle.t, Idle: now.Sub(sn.idle.t), Parked: sn.parked})
+	}
+	for _, t := range inUse {
+		ages = append(ages, ConnAge{Created: t, InUse: true})
+	}
+	sort.SliceStable(ages, func(i, j int) bool { return ages[i].Created.Before(ages[j].Created) })
+	return ages
+}
+
+// removeWaiterLocked 将req从waitingQueue移除，req已被取出则回传false，调用者需持有锁
+func (cp *channelPool) removeWaiterLocked(req chan idleConn) bool {
+	for i, w := range cp.waitingQueue {
+		if w.req == req {
+			copy(cp.waitingQueue[i:], cp.waitingQueue[i+1:])
+			cp.waitingQueue[len(cp.waitingQueue)-1] = nil
+			cp.waitingQueue = cp.waitingQueue[:len(cp.waitingQueue)-1]
+			return true
+		}
+	}
+	return false
+}
+
+// abandonWaiter 放弃等待req，若连接已经交给req则转交给下一个请求或放回pool，避免连接遗失
+func (cp *channelPool) abandonWaiter(req chan idleConn) {
+	cp.Lock()
+	if cp.removeWaiterLocked(req) {
+		cp.Unlock()
+		return
+	}
+	cp.Unlock()
+	ret, ok := <-req
+	if !ok || ret.err != nil {
+		return
+	}
+	//收到的是Close空出名额的通知，转交给下一个等待的请求
+	if ret.conn == nil {
+		cp.Lock()
+		if next := cp.popWaiterLocked(); next != nil {
+			next <- idleConn{}
+		}
+		cp.Unlock()
+		return
+	}
+	//设定ValidateAbandoned时先检查连接，未通过则关闭，空出的名额交给下一个等待的请求
+	if cp.checkAbandon && !cp.abandonedUsable(ret.conn) {
+		cp.closeAs(ret.conn, CloseReset)
+	} else {
+		cp.Put(ret.conn)
+	}
+	//PutSync交出的连接已改放回pool，不再让放回的协程等待
+	if ret.ack != nil {
+		close(ret.ack)
+	}
+}
+
+// abandonedUsable 以validate与ping检查交给已放弃等待的请求的连接，未设定的检查视为通过
+func (cp *channelPool) abandonedUsable(conn interface{}) bool {
+	if cp.validate != nil && !cp.validate(conn) {
+		return false
+	}
+	return cp.ping == nil || cp.ping(conn) == nil
+}
+
+// CancelWaiters 唤醒所有阻塞等待中的Get并让它们回传err，pool不会被关闭，回传被取消的请求数
+// 适用于已知后端失效时让等待中的请求立即失败，err为nil时回传ErrCanceled
+func (cp *channelPool) CancelWaiters(err error) int {
+	if err == nil {
+		err = ErrCanceled
+	}
+	n := 0
+	cp.Lock()
+	for req := cp.popWaiterLocked(); req != nil; req = cp.popWaiterLocked() {
+		req <- idleConn{err: err}
+		n++
+	}
+	cp.Unlock()
+	return n
+}
+
+// Drain 让pool进入排空状态：Get不再取出连接，回传ErrDraining，之后放回的连接直接关闭
+// 空闲连接会立即关闭，阻塞等待的Get会被唤醒并回传ErrDraining，已开启连接数归零后即可Release
+func (cp *channelPool) Drain() {
+	cp.Lock()
+	if cp.closed || cp.draining {
+		cp.Unlock()
+		return
+	}
+	cp.draining = true
+	idle := append(cp.freeConn, cp.parked...)
+	cp.freeConn = nil
+	cp.parked = nil
+	cp.numOpen -= len(idle)
+	//唤醒的请求重新检查时会发现pool在排空状态
+	for req := cp.popWaiterLocked(); req != nil; req = cp.popWaiterLocked() {
+		req <- idleConn{}
+	}
+	cp.Unlock()
+
+	for _, ic := range idle {
+		cp.closeConn(ic.conn, CloseRelease)
+	}
+}
+
+// Release 释放连接池中所有连接，并唤醒所有等待的请求回传ErrPoolClosed，最后关闭Events与CapacityAvailable的通道，重复调用不做任何事
+func (cp *channelPool) Release() {
+	if cp.putQueue != nil {
+		cp.putMu.Lock()
+		if !cp.putClosed {
+			cp.putClosed = true
+			close(cp.putDone)
+		}
+		cp.putMu.Unlock()
+	}
+
+	cp.Lock()
+	//已经Release过则不再处理，重复调用(例如信号处理与defer都调用)不会重复关闭连接
+	if cp.closed {
+		cp.Unlock()
+		return
+	}
+	if cp.closerDone != nil {
+		close(cp.closerDone)
+	}
+	cp.closed = true
+	freeConn := append(cp.freeConn, cp.parked...)
+	cp.freeConn = nil
+	cp.parked = nil
+	cp.numOpen -= len(freeConn)
+	for _, w := range cp.waitingQueue {
+		close(w.req)
+	}
+	cp.waitingQueue = nil
+	cp.Unlock()
+
+	cp.releaseConns(freeConn)
+	cp.closeSignals()
+}
+
+// ReleaseErrors 回传Release及ReleaseKeeping关闭空闲连接时OnRelease与各个close的错误，某个连接关闭失败时仍会继续关闭其它连接
+// 未调用Release或全部成功时回传nil。交给AsyncCloseQueue后台关闭的连接不在此列，可用OnCloseError取得
+func (cp *channelPool) ReleaseErrors() []error {
+	cp.Lock()
+	defer cp.Unlock()
+	if len(cp.releaseErrs) == 0 {
+		return nil
+	}
+	errs := make([]error, len(cp.releaseErrs))
+	copy(errs, cp.releaseErrs)
+	return errs
+}
+
+// Maintain 执行一次维护并回传各项处理的数量，供不想使用后台协程的调用者定期调用：
+// 关闭超过IdleTimeout的空闲连接，ping其余空闲连接并关闭失败的连接，最后将空闲连接修剪到MaxIdle个；
+// 设定ValidateIdleThreshold时只ping空闲超过该时间的连接
+// ping期间连接暂时移出freeConn，不会同时被Get取得
+func (cp *channelPool) Maintain() MaintainResult {
+	var res MaintainResult
+	cp.Lock()
+	if cp.closed {
+		cp.Unlock()
+		return res
+	}
+	var drop []closing
+	var candidates, recent []*idleConn
+	for _, ic := range cp.freeConn {
+		if cp.expiredLocked(ic.conn) {
+			drop = append(drop, closing{ic.conn, CloseLifetime})
+			cp.releaseSlotLocked()
+			cp.closedLifetime++
+			res.Expired++
+		} else if cp.idleTimeout > 0 && ic.t.Add(cp.idleTimeout).Before(time.Now()) {
+			drop = append(drop, closing{ic.conn, CloseIdle})
+			cp.releaseSlotLocked()
+			cp.closedIdle++
+			res.Reaped++
+		} else if cp.ping != nil && (cp.validateIdle <= 0 || time.Since(ic.t) > cp.validateIdle) {
+			candidates = append(candidates, ic)
+		} else {
+			//没有ping或空闲未超过ValidateIdleThreshold的连接不需要ping
+			recent = append(recent, ic)
+		}
+	}
+	cp.freeConn = append(cp.freeConn[:0], recent...)
+	for _, ic := range candidates {
+		cp.pinging[ic] = struct{}{}
+	}
+	cp.Unlock()
+
+	//ping为用户的方法，不持有锁调用
+	alive := make([]*idleConn, 0, len(candidates))
+	for _, ic := range candidates {
+		res.Pinged++
+		if cp.ping(ic.conn) != nil {
+			drop = append(drop, closing{ic.conn, CloseReset})
+			res.Removed++
+		} else {
+			alive = append(alive, ic)
+		}
+	}
+
+	cp.Lock()
+	for _, ic := range candidates {
+		delete(cp.pinging, ic)
+	}
+	for i := 0; i < res.Removed; i++ {
+		cp.releaseSlotLocked()
+	}
+	var back []*idleConn
+	for _, ic := range alive {
+		//维护期间pool被关闭或进入排空状态则直接关闭，否则保留原本的空闲时间放回
+		if cp.closed || cp.draining {
+			cp.numOpen--
+			drop = append(drop, closing{ic.conn, CloseRelease})
+		} else if req := cp.popWaiterLocked(); req != nil {
+			req <- idleConn{conn: ic.conn, inUse: true, t: time.Now()}
+		} else {
+			back = append(back, ic)
+		}
+	}
+	//ping过的连接比留在freeConn中的连接空闲更久，放回前面
+	if len(back) > 0 {
+		cp.freeConn = append(back, cp.freeConn...)
+	}
+	//freeConn前面是较早放回的连接，优先修剪
+	if excess := len(cp.freeConn) - cp.maxIdle; cp.maxIdle > 0 && excess > 0 {
+		for _, ic := range cp.freeConn[:excess] {
+			drop = append(drop, closing{ic.conn, CloseExcess})
+			cp.releaseSlotLocked()
+		}
+		cp.freeConn = append(cp.freeConn[:0], cp.freeConn[excess:]...)
+		res.Trimmed = excess
+	}
+	cp.Unlock()
+
+	for _, c := range drop {
+		cp.closeConn(c.conn, c.reason)
+	}
+	return res
+}
+
+// ReleaseKeeping 逐步释放pool，用于切换到新pool时让旧pool继续服务进行中的请求：
+// 立即关闭n个以外的空闲连接，保留的连接在d期间仍可正常Get、Put，d之后Release
+func (cp *channelPool) ReleaseKeeping(n int, d time.Duration) {
+	if n < 0 {
+		n = 0
+	}
+	cp.Lock()
+	if cp.closed {
+		cp.Unlock()
+		return
+	}
+	var extra []*idleConn
+	if len(cp.freeConn) > n {
+		extra = append(extra, cp.freeConn[n:]...)
+		cp.freeConn = cp.freeConn[:n]
+		cp.numOpen -= len(extra)
+	}
+	cp.Unlock()
+
+	cp.releaseConns(extra)
+	time.AfterFunc(d, cp.Release)
+}
+
+// releaseConns 以OnRelease通知后关闭已移出pool的空闲连接，错误记录在releaseErrs，由ReleaseErrors取得
+func (cp *channelPool) releaseConns(conns []*idleConn) {
+	var errs []error
+	for _, ic := range conns {
+		if cp.onRelease != nil {
+			if err := cp.onRelease(ic.conn); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := cp.closeConn(ic.conn, CloseRelease); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		cp.Lock()
+		cp.releaseErrs = append(cp.releaseErrs, errs...)
+		cp.Unlock()
+	}
+}
+
+// takeFreeLocked 从freeConn取出一个空闲连接并检查，调用者需持有锁，回传时仍持有锁
+// 回传nil时，dropped为true表示取出的连接已超时、未通过validate或ping失败而被关闭，可再尝试，否则表示没有空闲连接
+func (cp *channelPool) takeFreeLocked() (conn interface{}, dropped bool) {
+	numFree := len(cp.freeConn)
+	if numFree == 0 {
+		return nil, false
+	}
+	//默认取最早放回的连接，设定IdleLess时持锁线性扫描取它认为最好的连接
+	//不用heap：Maintain修剪最早放回的连接、ReleaseKeeping保留前n个，都依赖freeConn按放回顺序排列，且移出时的copy本来就是O(n)
+	best := 0
+	if cp.idleLess != nil {
+		for i := 1; i < numFree; i++ {
+			if cp.idleLess(cp.freeConn[i].conn, cp.freeConn[best].conn) {
+				best = i
+			}
+		}
+	}
+	ic := cp.freeConn[best]
+	copy(cp.freeConn[best:], cp.freeConn[best+1:])
+	cp.freeConn = cp.freeConn[:numFree-1]
+	return cp.checkoutLocked(ic)
+}
+
+// checkoutLocked 检查已从freeConn移出的空闲连接：超过IdleTimeout、未通过validate、ping或ValidateOnBorrow时
+// 关闭该连接(设定Quarantine时改交给Quarantine)并回传dropped为true，调用者需持有锁，回传时仍持有锁
+func (cp *channelPool) checkoutLocked(ic *idleConn) (conn interface{}, dropped bool) {
+	ic.inUse = true
+	//判断是否超时，超时则丢弃并关闭该连接
+	//用户设定的方法都在释放锁之后才调用，避免其中再调用pool的方法造成死锁
+	if timeout := cp.idleTimeout; timeout > 0 {
+		if ic.t.Add(timeout).Before(time.Now()) {
+			cp.closedIdle++
+			cp.discardLocked(ic.conn, CloseIdle)
+			return nil, true
+		}
+	}
+	if cp.expiredLocked(ic.conn) || cp.recycleEarlyLocked(ic.conn) {
+		cp.closedLifetime++
+		cp.discardLocked(ic.conn, CloseLifetime)
+		return nil, true
+	}
+	//空闲超过validateIdle的连接需先ping过才能使用
+	stale := cp.ping != nil && cp.validateIdle > 0 && time.Since(ic.t) > cp.validateIdle
+	if cp.validate == nil && cp.onBorrow == nil && !stale {
+		return ic.conn, false
+	}
+	//未通过validate检查、ping或ValidateOnBorrow失败则丢弃并关闭该连接，设定Quarantine时改交给Quarantine
+	cp.Unlock()
+	reason := ""
+	if cp.validate != nil && !cp.validate(ic.conn) {
+		reason = "validate failed"
+	} else if stale {
+		if err := cp.ping(ic.conn); err != nil {
+			reason = "ping failed: " + err.Error()
+		}
+	}
+	if reason == "" && cp.onBorrow != nil {
+		if err := cp.onBorrow(ic.conn); err != nil {
+			reason = "validate on borrow failed: " + err.Error()
+		}
+	}
+	cp.Lock()
+	if reason == "" {
+		return ic.conn, false
+	}
+	if cp.quarantine == nil {
+		cp.discardLocked(ic.conn, CloseReset)
+		return nil, true
+	}
+	cp.quarantined++
+	cp.releaseSlotLocked()
+	cp.Unlock()
+	//交给Quarantine的连接不经过closeConn，在这里移除它的记录
+	cp.forget(ic.conn)
+	cp.quarantine(ic.conn, reason)
+	cp.Lock()
+	return nil, true
+}
+
+// discardLocked 丢弃Get取出的不可用连接，numOpen立即减一，调用者需持有锁，回传时仍持有锁
+// 设定AsyncCloseQueue时送入关闭队列由后台协程关闭，让Get尽快回传；队列已满或未设定时释放锁后直接关闭
+func (cp *channelPool) discardLocked(conn interface{}, reason CloseReason) {
+	cp.numOpen--
+	if cp.closeQueue != nil && !cp.closed {
+		select {
+		case cp.closeQueue <- closing{conn, reason}:
+			return
+		default:
+		}
+	}
+	cp.Unlock()
+	cp.closeConn(conn, reason)
+	cp.Lock()
+}
+
+// closer 后台关闭closeQueue中的连接，Release后关闭剩余的连接即结束
+// 只有在持锁且pool未关闭时才会送入closeQueue，所以closerDone关闭后不会再有新的连接
+func (cp *channelPool) closer() {
+	for {
+		select {
+		case c := <-cp.closeQueue:
+			cp.closeConn(c.conn, c.reason)
+		case <-cp.closerDone:
+			for {
+				select {
+				case c := <-cp.closeQueue:
+					cp.closeConn(c.conn, c.reason)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// superviseBackoff 后台协程panic后重新执行前的等待策略，避免持续panic时空转
+var superviseBackoff Backoff = ExponentialBackoff{Initial: 10 * time.Millisecond, Max: time.Second, Multiplier: 2}
+
+// supervise 在后台协程执行loop，loop panic(例如用户设定的方法panic)时记录后等待一段时间重新执行，loop正常返回时结束
+func (cp *channelPool) supervise(name string, loop func()) {
+	go func() {
+		for attempt := 1; cp.runLoop(name, loop); attempt++ {
+			time.Sleep(superviseBackoff.NextInterval(attempt))
+		}
+	}()
+}
+
+// runLoop 执行loop，回传loop是否panic
+func (cp *channelPool) runLoop(name string, loop func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("pool %q: %s panic: %v, restarting", cp.name, name, r)
+			panicked = true
+		}
+	}()
+	loop()
+	return false
+}
+
+// putter 后台取出putQueue中已有的连接，整批以PutAll放回，只取一次锁；Release后放回剩余的连接即结束
+func (cp *channelPool) putter() {
+	batch := make([]interface{}, 0, cap(cp.putQueue))
+	for {
+		done := false
+		select {
+		case conn := <-cp.putQueue:
+			batch = append(batch[:0], conn)
+		case <-cp.putDone:
+			batch, done = batch[:0], true
+		}
+	DRAIN:
+		for len(batch) < cap(batch) || done {
+			select {
+			case conn := <-cp.putQueue:
+				batch = append(batch, conn)
+			default:
+				break DRAIN
+			}
+		}
+		if len(batch) > 0 {
+			cp.putBatch(batch)
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// putBatch 以PutAll放回putQueue取出的一批连接，完成后(含panic)才从numPutPending扣除，
+// 让WaitReady在连接进入freeConn前一直看得到它们
+func (cp *channelPool) putBatch(batch []interface{}) {
+	defer atomic.AddInt64(&cp.numPutPending, -int64(len(batch)))
+	cp.PutAll(batch)
+}
+
+// getWithBlock 取得连接，block表示连接数已达上限时是否阻塞等待，cancel被关闭时放弃等待
+// info不为nil时记录是否曾阻塞等待
+func (cp *channelPool) getWithBlock(block bool, cancel <-chan struct{}, info *GetInfo) (interface{}, error) {
+	conn, err := cp.get(block, cancel, info)
+	if err == nil {
+		cp.emit(EventAcquired, conn)
+		cp.trackAcquired(conn)
+		cp.checkSaturation()
+	}
+	return conn, err
+}
+
+// get getWithBlock的实现
+func (cp *channelPool) get(block bool, cancel <-chan struct{}, info *GetInfo) (interface{}, error) {
+	waited := false
+	evicted := false //AlwaysNewConn时已关闭过一个空闲连接
+	//最后一次登记的等待，离开时标记，若因异常而仍留在waitingQueue中，Put会略过它
+	var w *waiter
+	defer func() {
+		if w != nil {
+			atomic.StoreInt32(&w.gone, 1)
+		}
+	}()
+	cp.Lock()
+RETRY:
+	if cp.closed {
+		cp.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if cp.draining {
+		cp.Unlock()
+		return nil, ErrDraining
+	}
+
+	//从freeConn取一个空闲连接，取出的连接被丢弃时重新检查
+	if cp.strategy == CachedOrNewConn {
+		conn, dropped := cp.takeFreeLocked()
+		if conn != nil {
+			cp.Unlock()
+			return conn, nil
+		}
+		if dropped {
+			goto RETRY
+		}
+	} else if len(cp.freeConn) > 0 && (!evicted || cp.maxOpen > 0 && cp.numOpen >= cp.maxOpen) {
+		//AlwaysNewConn时建立前关闭一个最早放回的空闲连接，MaxCap为0时也避免放回的连接无限累积；
+		//名额在关闭期间被其它协程占去时再关闭一个，不让空闲连接占着名额而等待
+		evicted = true
+		ic := cp.freeConn[0]
+		cp.freeConn = append(cp.freeConn[:0], cp.freeConn[1:]...)
+		cp.discardLocked(ic.conn, CloseExcess)
+		goto RETRY
+	}
+
+	//如果没有空闲连接，而且当前建立的连接数已经达到最大限制则将请求加入waitingQueue队列，
+	//并阻塞在这里，直到其它协程将占用的连接释放或connectionOpenner创建
+	if cp.maxOpen > 0 && cp.numOpen >= cp.maxOpen {
+		if !block {
+			cp.Unlock()
+			return nil, ErrPoolExhausted
+		}
+		if cp.maxWaiters > 0 && len(cp.waitingQueue) >= cp.maxWaiters {
+			cp.Unlock()
+			atomic.AddInt64(&cp.numWaitRejected, 1)
+			return nil, ErrTooManyWaiters
+		}
+		// Make the connRequest channel. It's buffered so that the
+		// connectionOpener doesn't block while waiting for the req to be read.
+		req := make(chan idleConn, 1)
+		w = &waiter{req: req}
+		cp.waitingQueue = append(cp.waitingQueue, w)
+		cp.Unlock()
+		if info != nil {
+			info.Waited = true
+		}
+		cp.emit(EventWaitStarted, nil)
+		select {
+		case ret, ok := <-req: //阻塞
+			cp.emit(EventWaitEnded, nil)
+			conn, retry, err := received(ret, ok, info)
+			//其它协程Close连接空出了名额，重新尝试取得连接
+			if retry {
+				cp.Lock()
+				goto RETRY
+			}
+			return conn, err
+		case <-cancel:
+			cp.emit(EventWaitEnded, nil)
+			cp.abandonWaiter(req)
+			return nil, ErrCanceled
+		}
+	}
+
+	//已有连接正在建立时，先短暂等待其它协程放回的连接，等不到才自己建立，避免冷启动时同时建立大量连接
+	if block && !waited && cp.createWait > 0 && cp.numCreating > 0 {
+		waited = true
+		req := make(chan idleConn, 1)
+		w = &waiter{req: req}
+		cp.waitingQueue = append(cp.waitingQueue, w)
+		cp.Unlock()
+		if info != nil {
+			info.Waited = true
+		}
+		cp.emit(EventWaitStarted, nil)
+		timer := time.NewTimer(cp.createWait)
+		select {
+		case ret, ok := <-req:
+			timer.Stop()
+			cp.emit(EventWaitEnded, nil)
+			conn, retry, err := received(ret, ok, info)
+			if retry {
+				cp.Lock()
+				goto RETRY
+			}
+			return conn, err
+		case <-cancel:
+			timer.Stop()
+			cp.emit(EventWaitEnded, nil)
+			cp.abandonWaiter(req)
+			return nil, ErrCanceled
+		case <-timer.C:
+			cp.emit(EventWaitEnded, nil)
+		}
+		cp.Lock()
+		//超时的同时可能已经收到连接
+		if !cp.removeWaiterLocked(req) {
+			cp.Unlock()
+			ret, ok := <-req
+			conn, retry, err := received(ret, ok, info)
+			if retry {
+				cp.Lock()
+				goto RETRY
+			}
+			return conn, err
+		}
+		goto RETRY
+	}
+
+	if cp.quotaExhaustedLocked() {
+		cp.Unlock()
+		return nil, ErrQuotaExhausted
+	}
+	cp.beginCreateLocked()
+	cp.Unlock()
+	conn, err := cp.createWithCancel(cancel)
+	if err == errCreateOverflow {
+		cp.Lock()
+		goto RETRY
+	}
+	if err != nil {
+		return nil, err
 	}
 	ic := &idleConn{conn: conn, inUse: true, t: time.Now()}
 	return ic.conn, nil
 }
+
+// received 处理阻塞等待时收到的结果，retry为true表示有名额空出，需重新尝试取得连接
+func received(ret idleConn, ok bool, info *GetInfo) (conn interface{}, retry bool, err error) {
+	if !ok {
+		return nil, false, ErrPoolClosed
+	}
+	if ret.err != nil {
+		if info != nil {
+			info.WakeReason = WakeCanceled
+		}
+		return nil, false, ret.err
+	}
+	if ret.conn == nil {
+		return nil, true, nil
+	}
+	if ret.ack != nil {
+		close(ret.ack)
+	}
+	return ret.conn, false, nil
+}
+
+// beginCreateLocked 登记一个即将建立的连接，调用者需持有锁
+func (cp *channelPool) beginCreateLocked() {
+	//调用者对maxOpen的检查与这里的numOpen++在同一次持锁内完成，所以即使多个协程同时建立连接，numOpen也不会超过maxOpen
+	//PessimisticCreate时则等factory成功后才加一，建立期间不占名额，成功时名额已满则关闭该连接，见createWithCancel
+	if !cp.pessimistic {
+		cp.numOpen++ //numOpen是已经建立或即将建立连接数，这里还没有建立连接，只是乐观的认为后面会成功，失败的时候再将此值减1
+	}
+	cp.numCreating++
+	cp.numReserved++
+}
+
+// quotaExhaustedLocked 回传是否已用完MaxTotalCreated的建立额度，调用者需持有锁
+func (cp *channelPool) quotaExhaustedLocked() bool {
+	return cp.maxTotal > 0 && cp.numReserved >= cp.maxTotal
+}
+
+// errCreateOverflow PessimisticCreate建立成功时名额已被其它协程占满，连接已关闭，调用者应重新尝试取得连接
+var errCreateOverflow = errors.New("pool is full after create")
+
+// createWithCancel 为beginCreateLocked登记的连接调用factory，完成后归还numCreating，失败时归还名额
+// cancel被关闭时不再等待，回传ErrCanceled，之后才建立成功的连接会被关闭
+func (cp *channelPool) createWithCancel(cancel <-chan struct{}) (interface{}, error) {
+	type result struct {
+		conn interface{}
+		err  error
+	}
+	done := make(chan result, 1)
+	create := func() {
+		conn, err := cp.create()
+		cp.Lock()
+		cp.numCreating--
+		if err != nil {
+			cp.numReserved--
+		}
+		//PessimisticCreate时建立期间不占名额，成功后再检查一次，其它协程已占满名额则关闭多建立的连接
+		overflow := false
+		if cp.pessimistic {
+			if err == nil && cp.maxOpen > 0 && cp.numOpen >= cp.maxOpen {
+				overflow = true
+			} else if err == nil {
+				cp.numOpen++
+			}
+		} else if err != nil {
+			cp.releaseSlotLocked()
+		}
+		cp.Unlock()
+		if overflow {
+			cp.closeConn(conn, CloseExcess)
+			conn, err = nil, errCreateOverflow
+		}
+		done <- result{conn, err}
+	}
+	if cancel == nil {
+		create()
+		r := <-done
+		return r.conn, r.err
+	}
+
+	go create()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-cancel:
+		go func() {
+			if r := <-done; r.err == nil {
+				cp.closeAs(r.conn, CloseExcess)
+			}
+		}()
+		return nil, ErrCanceled
+	}
+}
@@ -0,0 +1,62 @@
+package pool
+
+import "io"
+
+// CloserPool 连接类型为io.Closer的pool，自动以io.Closer.Close关闭连接，不需要另外设定Close方法
+// Get、GetTry、Put、Close改用io.Closer型别，其余方法与Pool相同
+type CloserPool struct {
+	Pool
+}
+
+// NewPoolCloser 以回传io.Closer的factory建立pool，cfg中的Factory与Close会被忽略
+func NewPoolCloser(factory func() (io.Closer, error), cfg Config) (*CloserPool, error) {
+	if factory == nil {
+		return nil, ErrInvalidFactoryFunc
+	}
+	cfg.Factory = func() (interface{}, error) {
+		c, err := factory()
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	cfg.Close = func(v interface{}) error { return v.(io.Closer).Close() }
+	p, err := NewPool(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &CloserPool{Pool: p}, nil
+}
+
+// Get 从pool中取一个连接
+func (p *CloserPool) Get() (io.Closer, error) {
+	return toCloser(p.Pool.Get())
+}
+
+// GetTry 从pool中取一个连接，连接数已达到最大限制时不阻塞，回传nil
+func (p *CloserPool) GetTry() (io.Closer, error) {
+	return toCloser(p.Pool.GetTry())
+}
+
+// Put 将连接放回pool中
+func (p *CloserPool) Put(c io.Closer) error {
+	if c == nil {
+		return ErrConnIsNil
+	}
+	return p.Pool.Put(c)
+}
+
+// Close 關閉一條連線，並將已開啟連線數減一
+func (p *CloserPool) Close(c io.Closer) error {
+	if c == nil {
+		return ErrConnIsNil
+	}
+	return p.Pool.Close(c)
+}
+
+func toCloser(v interface{}, err error) (io.Closer, error) {
+	if v == nil {
+		return nil, err
+	}
+	return v.(io.Closer), err
+}
@@ -0,0 +1,44 @@
+package pool
+
+import (
+	"io"
+	"testing"
+)
+
+type fakeCloser struct {
+	closed bool
+}
+
+func (c *fakeCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewPoolCloser(t *testing.T) {
+	p, err := NewPoolCloser(func() (io.Closer, error) { return &fakeCloser{}, nil }, Config{InitialCap: 1, MaxCap: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(c); err != nil {
+		t.Fatal(err)
+	}
+	d, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != c {
+		t.Errorf("Get after Put = %v, want %v", d, c)
+	}
+	if err := p.Close(d); err != nil {
+		t.Fatal(err)
+	}
+	if !d.(*fakeCloser).closed {
+		t.Error("Close did not call io.Closer.Close")
+	}
+	p.Release()
+}
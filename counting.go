@@ -0,0 +1,202 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// CountingConn 计算读写字节数的net.Conn，其余方法直接使用原本的连接
+type CountingConn struct {
+	net.Conn
+	pool *CountingTCPPool
+}
+
+// Read 从连接读取，并累计读取的字节数
+func (c *CountingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.pool.bytesRead, int64(n))
+	return n, err
+}
+
+// Write 写入连接，并累计写入的字节数
+func (c *CountingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.pool.bytesWritten, int64(n))
+	return n, err
+}
+
+// CountingTCPPool 连接类型为net.Conn的pool，取出的连接为*CountingConn，
+// 所有连接的读写字节数累计在Stats的BytesRead、BytesWritten
+type CountingTCPPool struct {
+	//atomic操作，放在struct开头以确保64位对齐
+	bytesRead    int64
+	bytesWritten int64
+
+	Pool
+	keepAlive time.Duration //新建立的*net.TCPConn的keepalive间隔，0表示不设定
+}
+
+// NewCountingTCPPool 以回传net.Conn的factory建立pool，cfg中的Factory与Close会被忽略
+func NewCountingTCPPool(factory func() (net.Conn, error), cfg Config) (*CountingTCPPool, error) {
+	if factory == nil {
+		return nil, ErrInvalidFactoryFunc
+	}
+	cp := &CountingTCPPool{keepAlive: cfg.TCPKeepAlive}
+	cfg.Factory = cp.counted(func() (interface{}, error) { return factory() })
+	cfg.Close = closeCounted
+	p, err := NewPool(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	cp.Pool = p
+	return cp, nil
+}
+
+// counted 包装factory，回传的net.Conn(含其它pool的*CountingConn)都改为计入p的*CountingConn，
+// 设定keepAlive时对*net.TCPConn启用keepalive，设定失败则关闭该连接并回传错误
+func (p *CountingTCPPool) counted(factory func() (interface{}, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		v, err := factory()
+		if err != nil {
+			return nil, err
+		}
+		var conn net.Conn
+		switch c := v.(type) {
+		case *CountingConn:
+			conn = c.Conn
+		case net.Conn:
+			conn = c
+		default:
+			return nil, fmt.Errorf("factory returned %T, want net.Conn", v)
+		}
+		if err := p.setKeepAlive(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &CountingConn{Conn: conn, pool: p}, nil
+	}
+}
+
+// setKeepAlive 对*net.TCPConn启用keepalive并设定间隔，keepAlive为0或其它类型的连接不处理
+func (p *CountingTCPPool) setKeepAlive(conn net.Conn) error {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok || p.keepAlive <= 0 {
+		return nil
+	}
+	if err := tc.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return tc.SetKeepAlivePeriod(p.keepAlive)
+}
+
+// closeCounted 关闭*CountingConn，其它net.Conn直接关闭
+func closeCounted(v interface{}) error {
+	switch c := v.(type) {
+	case *CountingConn:
+		return c.Close()
+	case net.Conn:
+		return c.Close()
+	}
+	return fmt.Errorf("close got %T, want net.Conn", v)
+}
+
+// SetFactory 替换factory，新factory回传的net.Conn同样计算读写字节数
+func (p *CountingTCPPool) SetFactory(factory func() (interface{}, error)) error {
+	if factory == nil {
+		return ErrInvalidFactoryFunc
+	}
+	return p.Pool.SetFactory(p.counted(factory))
+}
+
+// GetContextDeadline 以GetContext取得连接，ctx有期限时将它设为连接的读写期限，Put、Return、PutSync、PutAll放回时清除；
+// ctx没有期限时不设定。设定失败则关闭该连接并回传错误
+func (p *CountingTCPPool) GetContextDeadline(ctx context.Context) (net.Conn, error) {
+	v, err := p.Pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn := v.(*CountingConn)
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			p.Pool.Close(conn)
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Put 清除连接的读写期限后放回pool，避免GetContextDeadline设定的期限影响下一个使用者
+func (p *CountingTCPPool) Put(conn interface{}) error {
+	clearDeadline(conn)
+	return p.Pool.Put(conn)
+}
+
+// Return 同Put
+func (p *CountingTCPPool) Return(conn interface{}) error {
+	return p.Put(conn)
+}
+
+// PutSync 清除连接的读写期限后以PutSync放回
+func (p *CountingTCPPool) PutSync(conn interface{}) error {
+	clearDeadline(conn)
+	return p.Pool.PutSync(conn)
+}
+
+// PutFresh 清除连接的读写期限后以PutFresh放回
+func (p *CountingTCPPool) PutFresh(conn interface{}) error {
+	clearDeadline(conn)
+	return p.Pool.PutFresh(conn)
+}
+
+// PutAll 清除每个连接的读写期限后整批放回
+func (p *CountingTCPPool) PutAll(conns []interface{}) error {
+	for _, conn := range conns {
+		clearDeadline(conn)
+	}
+	return p.Pool.PutAll(conns)
+}
+
+// clearDeadline 清除*CountingConn的读写期限，其它类型不处理
+func clearDeadline(conn interface{}) {
+	if c, ok := conn.(*CountingConn); ok {
+		c.SetDeadline(time.Time{})
+	}
+}
+
+// Clone 以相同的配置建立新的CountingTCPPool，overrides依序修改配置，新pool的读写字节数另外累计
+func (p *CountingTCPPool) Clone(overrides ...Option) (Pool, error) {
+	c := &CountingTCPPool{}
+	//原本的factory产生的连接计入p，改为计入c；overrides替换的factory回传的net.Conn也一样包装
+	recount := func(cfg *Config) {
+		c.keepAlive = cfg.TCPKeepAlive
+		if cfg.Factory != nil {
+			cfg.Factory = c.counted(cfg.Factory)
+		}
+		cfg.Close = closeCounted
+	}
+	opts := make([]Option, 0, len(overrides)+1)
+	opts = append(opts, overrides...)
+	inner, err := p.Pool.Clone(append(opts, recount)...)
+	if err != nil {
+		return nil, err
+	}
+	c.Pool = inner
+	return c, nil
+}
+
+// Stats 回传pool的状态统计，包含累计的读写字节数
+func (p *CountingTCPPool) Stats() Stats {
+	st := p.Pool.Stats()
+	st.BytesRead = atomic.LoadInt64(&p.bytesRead)
+	st.BytesWritten = atomic.LoadInt64(&p.bytesWritten)
+	return st
+}
+
+// StatsJSON 回传包含读写字节数的Stats的JSON编码
+func (p *CountingTCPPool) StatsJSON() ([]byte, error) {
+	return json.Marshal(p.Stats())
+}
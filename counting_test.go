@@ -0,0 +1,291 @@
+package pool
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCountingTCPPool(t *testing.T) {
+	var peers []net.Conn
+	p, err := NewCountingTCPPool(func() (net.Conn, error) {
+		client, server := net.Pipe()
+		peers = append(peers, server)
+		//对端将收到的内容原样送回
+		go io.Copy(server, server)
+		return client, nil
+	}, Config{InitialCap: 1, MaxCap: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := v.(net.Conn)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if conn.LocalAddr() == nil {
+		t.Error("LocalAddr was not passed through")
+	}
+	if err := p.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	st := p.Stats()
+	if st.BytesWritten != 5 || st.BytesRead != 5 {
+		t.Errorf("BytesWritten = %d, BytesRead = %d, want 5 and 5", st.BytesWritten, st.BytesRead)
+	}
+	p.Release()
+	for _, s := range peers {
+		s.Close()
+	}
+}
+
+func TestCountingTCPPoolKeepAlive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	var dialed []net.Conn
+	p, err := NewCountingTCPPool(func() (net.Conn, error) {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			dialed = append(dialed, c)
+		}
+		return c, err
+	}, Config{MaxCap: 2, TCPKeepAlive: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := v.(net.Conn)
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("echo = %q, %v, want ping", buf, err)
+	}
+	if _, ok := dialed[0].(*net.TCPConn); !ok {
+		t.Fatalf("dialed %T, want *net.TCPConn", dialed[0])
+	}
+	p.Put(conn)
+
+	//非TCP的连接不处理
+	pipes, err := NewCountingTCPPool(func() (net.Conn, error) {
+		client, server := net.Pipe()
+		go io.Copy(ioutil.Discard, server)
+		return client, nil
+	}, Config{MaxCap: 1, TCPKeepAlive: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipes.Release()
+	if _, err := pipes.Get(); err != nil {
+		t.Errorf("Get from a pipe pool with TCPKeepAlive = %v, want nil", err)
+	}
+}
+
+func TestCountingTCPPoolClone(t *testing.T) {
+	var peers []net.Conn
+	var mu sync.Mutex
+	p, err := NewCountingTCPPool(func() (net.Conn, error) {
+		client, server := net.Pipe()
+		mu.Lock()
+		peers = append(peers, server)
+		mu.Unlock()
+		go io.Copy(server, server)
+		return client, nil
+	}, Config{MaxCap: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone, err := p.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := clone.(*CountingTCPPool); !ok {
+		t.Fatalf("Clone returned %T, want *CountingTCPPool", clone)
+	}
+
+	v, err := clone.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := v.(net.Conn)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	clone.Put(conn)
+
+	if st := clone.Stats(); st.BytesWritten != 5 {
+		t.Errorf("clone BytesWritten = %d, want 5", st.BytesWritten)
+	}
+	if st := p.Stats(); st.BytesWritten != 0 {
+		t.Errorf("original BytesWritten = %d, want 0", st.BytesWritten)
+	}
+	clone.Release()
+	p.Release()
+	for _, s := range peers {
+		s.Close()
+	}
+}
+
+func TestCountingTCPPoolRawFactory(t *testing.T) {
+	var mu sync.Mutex
+	var peers []net.Conn
+	raw := func() (interface{}, error) {
+		client, server := net.Pipe()
+		mu.Lock()
+		peers = append(peers, server)
+		mu.Unlock()
+		go io.Copy(ioutil.Discard, server)
+		return client, nil
+	}
+	p, err := NewCountingTCPPool(func() (net.Conn, error) {
+		c, err := raw()
+		return c.(net.Conn), err
+	}, Config{MaxCap: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetFactory(raw); err != nil {
+		t.Fatal(err)
+	}
+	clone, err := p.Clone(func(cfg *Config) { cfg.Factory = raw })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//SetFactory与Clone替换的factory回传未包装的net.Conn，同样计算字节数并能正常关闭
+	for _, pool := range []Pool{p, clone} {
+		v, err := pool.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := v.(*CountingConn); !ok {
+			t.Fatalf("Get returned %T, want *CountingConn", v)
+		}
+		v.(net.Conn).Write([]byte("abc"))
+		if err := pool.Close(v); err != nil {
+			t.Errorf("Close = %v, want nil", err)
+		}
+		if st := pool.Stats(); st.BytesWritten != 3 {
+			t.Errorf("BytesWritten = %d, want 3", st.BytesWritten)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, s := range peers {
+		if _, err := s.Write([]byte("x")); err != io.ErrClosedPipe {
+			t.Errorf("peer %d write = %v, want the pool side closed", i, err)
+		}
+	}
+	clone.Release()
+	p.Release()
+}
+
+// deadlineConn 记录最后一次SetDeadline设定的期限
+type deadlineConn struct {
+	net.Conn
+	deadline time.Time
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func TestGetContextDeadline(t *testing.T) {
+	var conns []*deadlineConn
+	var peers []net.Conn
+	p, err := NewCountingTCPPool(func() (net.Conn, error) {
+		client, server := net.Pipe()
+		peers = append(peers, server)
+		c := &deadlineConn{Conn: client}
+		conns = append(conns, c)
+		return c, nil
+	}, Config{InitialCap: 1, MaxCap: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	conn, err := p.GetContextDeadline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := conns[0].deadline; !got.Equal(want) {
+		t.Errorf("deadline = %v, want the context deadline %v", got, want)
+	}
+	if err := p.Put(conn); err != nil {
+		t.Fatal(err)
+	}
+	if got := conns[0].deadline; !got.IsZero() {
+		t.Errorf("deadline after Put = %v, want cleared", got)
+	}
+
+	//其它放回方法也清除期限
+	returns := map[string]func(net.Conn) error{
+		"Return":  func(c net.Conn) error { return p.Return(c) },
+		"PutSync": func(c net.Conn) error { return p.PutSync(c) },
+		"PutAll":  func(c net.Conn) error { return p.PutAll([]interface{}{c}) },
+	}
+	for name, put := range returns {
+		conn, err := p.GetContextDeadline(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := put(conn); err != nil {
+			t.Fatal(err)
+		}
+		if got := conns[0].deadline; !got.IsZero() {
+			t.Errorf("deadline after %s = %v, want cleared", name, got)
+		}
+	}
+
+	//ctx没有期限时不设定
+	conn, err = p.GetContextDeadline(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := conns[0].deadline; !got.IsZero() {
+		t.Errorf("deadline without a context deadline = %v, want zero", got)
+	}
+	p.Put(conn)
+	p.Release()
+	for _, s := range peers {
+		s.Close()
+	}
+}
@@ -0,0 +1,47 @@
+package pool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EventType PoolEvent的事件类型
+type EventType int
+
+const (
+	EventAcquired    EventType = iota //Get取得连接
+	EventReturned                     //连接被Put放回
+	EventCreated                      //factory建立了新连接
+	EventClosed                       //连接被关闭
+	EventWaitStarted                  //Get开始阻塞等待连接
+	EventWaitEnded                    //Get结束阻塞等待，不论是否取得连接
+)
+
+// PoolEvent Events回传的事件
+type PoolEvent struct {
+	Type EventType
+	Conn interface{} //相关的连接，WaitStarted、WaitEnded时为nil
+	Time time.Time
+}
+
+// Events 回传事件通道，Config.EventBuffer为0时回传nil
+// 通道满时新的事件会被丢弃而不阻塞pool的操作，丢弃的数量见Stats.EventsDropped；Release关闭空闲连接后关闭通道，之后的事件不再送出
+func (cp *channelPool) Events() <-chan PoolEvent {
+	return cp.events
+}
+
+// emit 送出事件，通道满时丢弃并累计丢弃数，可在持有锁时调用
+func (cp *channelPool) emit(typ EventType, conn interface{}) {
+	if cp.events == nil {
+		return
+	}
+	cp.signalMu.RLock()
+	if !cp.signalClosed {
+		select {
+		case cp.events <- PoolEvent{Type: typ, Conn: conn, Time: time.Now()}:
+		default:
+			atomic.AddInt64(&cp.numEventsDropped, 1)
+		}
+	}
+	cp.signalMu.RUnlock()
+}
@@ -0,0 +1,119 @@
+package pool
+
+import (
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, events <-chan PoolEvent) PoolEvent {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event arrived")
+		return PoolEvent{}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 1, &created)
+	poolConfig.EventBuffer = 16
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := p.Events()
+
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextEvent(t, events); ev.Type != EventCreated || ev.Conn != v {
+		t.Errorf("event = %+v, want Created for the new connection", ev)
+	}
+	if ev := nextEvent(t, events); ev.Type != EventAcquired || ev.Conn != v || ev.Time.IsZero() {
+		t.Errorf("event = %+v, want timestamped Acquired for the connection", ev)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		w, _ := p.Get()
+		p.Close(w)
+		close(done)
+	}()
+	waitForWaiters(t, p.(*channelPool), 1)
+	if ev := nextEvent(t, events); ev.Type != EventWaitStarted {
+		t.Errorf("event = %+v, want WaitStarted", ev)
+	}
+	if err := p.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	want := []EventType{EventReturned, EventWaitEnded, EventAcquired, EventClosed}
+	got := map[EventType]bool{}
+	for range want {
+		got[nextEvent(t, events).Type] = true
+	}
+	for _, typ := range want {
+		if !got[typ] {
+			t.Errorf("missing event type %d, got %v", typ, got)
+		}
+	}
+	p.Release()
+}
+
+func TestEventsClosedOnRelease(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(2, 2, &created)
+	poolConfig.EventBuffer = 16
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, capacity := p.Events(), p.CapacityAvailable()
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release()
+
+	//Release关闭空闲连接后才关闭通道，之后放回的连接不再送出事件
+	closed := 0
+	for ev := range events {
+		if ev.Type == EventClosed {
+			closed++
+		}
+	}
+	if closed != 1 {
+		t.Errorf("got %d Closed events, want 1 for the idle connection", closed)
+	}
+	for range capacity {
+	}
+	if err := p.Put(v); err != ErrPoolClosedAndClose {
+		t.Errorf("Put after Release = %v, want ErrPoolClosedAndClose", err)
+	}
+	p.Release()
+}
+
+func TestEventsDropWhenFull(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 2, &created)
+	poolConfig.EventBuffer = 1
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Stats().EventsDropped; n != 2 {
+		t.Errorf("EventsDropped = %d, want 2", n)
+	}
+	p.Release()
+}
@@ -21,7 +21,7 @@ var workerTryGetNum int = 5
 var idleTimeout time.Duration = time.Second
 
 func main() {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill, syscall.SIGUSR1, syscall.SIGUSR2)
 	go server()
 	//等待tcp server启动
@@ -0,0 +1,44 @@
+package pool
+
+import "sync"
+
+// HealthRegistry 多个pool共用的后端健康状态，以pool的名称(Config.Name)区分后端
+// pool建立连接失败、成功时回报，连续失败达到门槛的后端视为可疑，BalancedPool取连接时排在最后
+// 也可由调用者在连接读写失败时自行回报
+type HealthRegistry struct {
+	mu        sync.Mutex
+	threshold int
+	failures  map[string]int //名称 -> 连续失败次数
+}
+
+// NewHealthRegistry 建立HealthRegistry，连续失败threshold次后视为可疑，threshold小于1时视为1
+func NewHealthRegistry(threshold int) *HealthRegistry {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &HealthRegistry{
+		threshold: threshold,
+		failures:  make(map[string]int),
+	}
+}
+
+// ReportFailure 回报名称为name的后端失败一次
+func (h *HealthRegistry) ReportFailure(name string) {
+	h.mu.Lock()
+	h.failures[name]++
+	h.mu.Unlock()
+}
+
+// ReportSuccess 回报名称为name的后端成功，清除连续失败次数
+func (h *HealthRegistry) ReportSuccess(name string) {
+	h.mu.Lock()
+	delete(h.failures, name)
+	h.mu.Unlock()
+}
+
+// Suspect 回传名称为name的后端是否连续失败达到门槛
+func (h *HealthRegistry) Suspect(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failures[name] >= h.threshold
+}
@@ -0,0 +1,57 @@
+package pool
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHealthRegistry(t *testing.T) {
+	health := NewHealthRegistry(3)
+	var created1, created2 int32
+	cfg1 := newFakeConfig(0, 3, &created1)
+	cfg1.Name = "a"
+	cfg1.Health = health
+	cfg1.Factory = func() (interface{}, error) { return nil, errors.New("connection refused") }
+	p1, err := NewPool(cfg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg2 := newFakeConfig(0, 3, &created2)
+	cfg2.Name = "b"
+	cfg2.Health = health
+	p2, err := NewPool(cfg2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp, err := NewBalancedPool(p1, p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.SetHealthRegistry(health)
+	defer bp.Release()
+
+	//两个pool负载相同时先取p1，连续失败3次后p1成为可疑
+	for i := 0; i < 3; i++ {
+		if _, err := bp.Get(); err == nil {
+			t.Fatalf("Get #%d succeeded, want the failing backend to be tried", i)
+		}
+	}
+	if !health.Suspect("a") || health.Suspect("b") {
+		t.Fatalf("Suspect(a), Suspect(b) = %v, %v, want true, false", health.Suspect("a"), health.Suspect("b"))
+	}
+
+	//p2负载较高仍优先于可疑的p1
+	for i := 0; i < 3; i++ {
+		if _, err := bp.Get(); err != nil {
+			t.Fatalf("Get #%d after a is suspect = %v, want routed to b", i, err)
+		}
+	}
+	if in2 := p2.Stats().InUse; in2 != 3 {
+		t.Errorf("b InUse = %d, want 3", in2)
+	}
+
+	health.ReportSuccess("a")
+	if health.Suspect("a") {
+		t.Error("Suspect(a) after ReportSuccess = true, want false")
+	}
+}
@@ -0,0 +1,140 @@
+package pool
+
+import (
+	"context"
+	"time"
+)
+
+// Middleware 包装一个Pool并回传加上额外行为(日志、指标、重试等)的Pool
+// 实作时可嵌入原本的Pool，只覆写需要的方法，其余方法直接委派
+type Middleware func(Pool) Pool
+
+// Chain 依序以mws包装base，第一个middleware在最外层，最先执行
+func Chain(base Pool, mws ...Middleware) Pool {
+	p := base
+	for i := len(mws) - 1; i >= 0; i-- {
+		p = mws[i](p)
+	}
+	return p
+}
+
+// Timing 回传记录取出与放回连接耗时的Middleware，每次调用后以方法名称(例如"Get"、"GetContext"、"Borrow"、"Put"、"Return")与耗时调用observe
+// 记录所有取出连接的方法(Get、GetTry、GetTryReason、TryN、GetOrError、GetWithInfo、GetWithCancel、GetWithBudget、GetContext、GetBatch、GetIf、Borrow)
+// 与放回连接的方法(Put、PutAll、PutSync、PutFresh、Return)；WaitReady、Close等其它方法不记录
+func Timing(observe func(op string, d time.Duration)) Middleware {
+	return func(next Pool) Pool {
+		return &timingPool{Pool: next, observe: observe}
+	}
+}
+
+type timingPool struct {
+	Pool
+	observe func(op string, d time.Duration)
+}
+
+// since 以op与从start至今的耗时调用observe，以defer调用
+func (p *timingPool) since(op string, start time.Time) {
+	p.observe(op, time.Since(start))
+}
+
+// Get 调用下一层的Get并记录耗时
+func (p *timingPool) Get() (interface{}, error) {
+	defer p.since("Get", time.Now())
+	return p.Pool.Get()
+}
+
+// GetTry 调用下一层的GetTry并记录耗时
+func (p *timingPool) GetTry() (interface{}, error) {
+	defer p.since("GetTry", time.Now())
+	return p.Pool.GetTry()
+}
+
+// GetTryReason 调用下一层的GetTryReason并记录耗时
+func (p *timingPool) GetTryReason() (interface{}, TryReason, error) {
+	defer p.since("GetTryReason", time.Now())
+	return p.Pool.GetTryReason()
+}
+
+// TryN 调用下一层的TryN并记录耗时
+func (p *timingPool) TryN(validate func(interface{}) bool) (interface{}, error) {
+	defer p.since("TryN", time.Now())
+	return p.Pool.TryN(validate)
+}
+
+// GetOrError 调用下一层的GetOrError并记录耗时
+func (p *timingPool) GetOrError() (interface{}, error) {
+	defer p.since("GetOrError", time.Now())
+	return p.Pool.GetOrError()
+}
+
+// GetWithInfo 调用下一层的GetWithInfo并记录耗时
+func (p *timingPool) GetWithInfo() (interface{}, GetInfo, error) {
+	defer p.since("GetWithInfo", time.Now())
+	return p.Pool.GetWithInfo()
+}
+
+// GetWithCancel 调用下一层的GetWithCancel并记录耗时
+func (p *timingPool) GetWithCancel(cancel <-chan struct{}) (interface{}, error) {
+	defer p.since("GetWithCancel", time.Now())
+	return p.Pool.GetWithCancel(cancel)
+}
+
+// GetWithBudget 调用下一层的GetWithBudget并记录耗时
+func (p *timingPool) GetWithBudget(budget time.Duration) (interface{}, error) {
+	defer p.since("GetWithBudget", time.Now())
+	return p.Pool.GetWithBudget(budget)
+}
+
+// GetContext 调用下一层的GetContext并记录耗时
+func (p *timingPool) GetContext(ctx context.Context) (interface{}, error) {
+	defer p.since("GetContext", time.Now())
+	return p.Pool.GetContext(ctx)
+}
+
+// GetBatch 调用下一层的GetBatch并记录耗时
+func (p *timingPool) GetBatch(n int, timeout time.Duration) ([]interface{}, error) {
+	defer p.since("GetBatch", time.Now())
+	return p.Pool.GetBatch(n, timeout)
+}
+
+// GetIf 调用下一层的GetIf并记录耗时
+func (p *timingPool) GetIf(match func(interface{}) bool) (interface{}, error) {
+	defer p.since("GetIf", time.Now())
+	return p.Pool.GetIf(match)
+}
+
+// Borrow 调用下一层的Borrow并记录耗时
+func (p *timingPool) Borrow() (interface{}, error) {
+	defer p.since("Borrow", time.Now())
+	return p.Pool.Borrow()
+}
+
+// Put 调用下一层的Put并记录耗时
+func (p *timingPool) Put(conn interface{}) error {
+	defer p.since("Put", time.Now())
+	return p.Pool.Put(conn)
+}
+
+// PutAll 调用下一层的PutAll并记录耗时
+func (p *timingPool) PutAll(conns []interface{}) error {
+	defer p.since("PutAll", time.Now())
+	return p.Pool.PutAll(conns)
+}
+
+// PutSync 调用下一层的PutSync并记录耗时
+func (p *timingPool) PutSync(conn interface{}) error {
+	defer p.since("PutSync", time.Now())
+	return p.Pool.PutSync(conn)
+}
+
+// PutFresh 调用下一层的PutFresh并记录耗时
+func (p *timingPool) PutFresh(conn interface{}) error {
+	defer p.since("PutFresh", time.Now())
+	return p.Pool.PutFresh(conn)
+}
+
+// Return 调用下一层的Return并记录耗时
+func (p *timingPool) Return(conn interface{}) error {
+	defer p.since("Return", time.Now())
+	return p.Pool.Return(conn)
+}
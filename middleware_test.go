@@ -0,0 +1,131 @@
+package pool
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type tracePool struct {
+	Pool
+	name  string
+	trace *[]string
+}
+
+func (p *tracePool) Get() (interface{}, error) {
+	*p.trace = append(*p.trace, p.name+" before")
+	conn, err := p.Pool.Get()
+	*p.trace = append(*p.trace, p.name+" after")
+	return conn, err
+}
+
+func tracing(name string, trace *[]string) Middleware {
+	return func(next Pool) Pool {
+		return &tracePool{Pool: next, name: name, trace: trace}
+	}
+}
+
+func TestChain(t *testing.T) {
+	var created int32
+	base, err := NewPool(newFakeConfig(1, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace []string
+	p := Chain(base, tracing("outer", &trace), tracing("inner", &trace))
+
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"outer before", "inner before", "inner after", "outer after"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("trace = %v, want %v", trace, want)
+	}
+	if err := p.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	p.Release()
+}
+
+func TestTiming(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 1, &created)
+	factory := poolConfig.Factory
+	poolConfig.Factory = func() (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		return factory()
+	}
+	base, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	observed := map[string]time.Duration{}
+	p := Chain(base, Timing(func(op string, d time.Duration) { observed[op] = d }))
+
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	if observed["Get"] < 20*time.Millisecond {
+		t.Errorf("observed Get = %v, want at least the factory time", observed["Get"])
+	}
+	if _, ok := observed["Put"]; !ok {
+		t.Error("Put was not observed")
+	}
+	p.Release()
+}
+
+func TestTimingAllCheckouts(t *testing.T) {
+	var created int32
+	base, err := NewPool(newFakeConfig(0, 4, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer base.Release()
+	observed := map[string]int{}
+	p := Chain(base, Timing(func(op string, d time.Duration) { observed[op]++ }))
+	all := func(interface{}) bool { return true }
+
+	get := []func() (interface{}, error){
+		p.Get,
+		p.GetTry,
+		func() (interface{}, error) { v, _, err := p.GetTryReason(); return v, err },
+		func() (interface{}, error) { return p.TryN(all) },
+		p.GetOrError,
+		func() (interface{}, error) { v, _, err := p.GetWithInfo(); return v, err },
+		func() (interface{}, error) { return p.GetWithCancel(nil) },
+		func() (interface{}, error) { return p.GetWithBudget(time.Second) },
+		func() (interface{}, error) { return p.GetContext(context.Background()) },
+		func() (interface{}, error) { return p.GetIf(all) },
+		p.Borrow,
+	}
+	put := []func(interface{}) error{p.Put, p.PutSync, p.PutFresh, p.Return}
+	for i, g := range get {
+		v, err := g()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := put[i%len(put)](v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch, err := p.GetBatch(2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PutAll(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []string{"Get", "GetTry", "GetTryReason", "TryN", "GetOrError", "GetWithInfo", "GetWithCancel",
+		"GetWithBudget", "GetContext", "GetIf", "Borrow", "GetBatch", "Put", "PutSync", "PutFresh", "Return", "PutAll"} {
+		if observed[op] == 0 {
+			t.Errorf("%s was not observed", op)
+		}
+	}
+}
@@ -1,24 +1,75 @@
 package pool
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
 var (
-	ErrInvalidCapacity    = errors.New("invalid capacity settings")
-	ErrInvalidFactoryFunc = errors.New("invalid factory func settings")
-	ErrInvalidCloseFunc   = errors.New("invalid close func settings")
-	ErrInvalidPingFunc    = errors.New("invalid ping func settings")
-	ErrOpenNumber         = errors.New("numOpen > maxOpen")
-	ErrConnIsNil          = errors.New("connection is nil. rejecting")
-	ErrPoolClosed         = errors.New("pool is closed")
-	ErrPoolClosedAndClose = errors.New("connction pool is closed. close connection")
+	ErrInvalidCapacity       = errors.New("invalid capacity settings")
+	ErrInvalidFactoryFunc    = errors.New("invalid factory func settings")
+	ErrInvalidCloseFunc      = errors.New("invalid close func settings")
+	ErrInvalidPingFunc       = errors.New("invalid ping func settings")
+	ErrOpenNumber            = errors.New("numOpen > maxOpen")
+	ErrConnIsNil             = errors.New("connection is nil. rejecting")
+	ErrPoolClosed            = errors.New("pool is closed")
+	ErrPoolClosedAndClose    = errors.New("connction pool is closed. close connection")
+	ErrPoolExhausted         = errors.New("pool is exhausted")
+	ErrCanceled              = errors.New("get connection canceled")
+	ErrBudgetExceeded        = errors.New("get connection budget exceeded")
+	ErrDraining              = errors.New("pool is draining")
+	ErrUnknownConn           = errors.New("connection does not belong to the pool")
+	ErrWaitTimeout           = errors.New("get connection wait timeout")
+	ErrFactoryFailed         = errors.New("factory failed")
+	ErrFactoryReturnedNil    = errors.New("factory returned a nil connection without error")
+	ErrQuotaExhausted        = errors.New("pool has created its maximum total connections")
+	ErrDrainingCloseOnReturn = errors.New("connection pool is draining. close connection")
+	ErrCloseFailed           = errors.New("close func panicked")
+	ErrTooManyWaiters        = errors.New("too many waiters for connection")
+	ErrConnExpired           = errors.New("connection exceeded max lifetime. close connection")
 )
 
+// MultiError 批次操作中多个连接各自的错误
+type MultiError []error
+
+func (e MultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// GetError factory(含重试)建立连接失败时回传的错误，errors.Is(err, ErrFactoryFailed)成立，
+// 可用errors.As取得factory的调用次数与最后一次的错误，或进一步取得最后错误的具体类型(例如*net.DNSError)
+type GetError struct {
+	Attempts int   //factory的调用次数
+	Err      error //最后一次factory回传的错误
+}
+
+func (e *GetError) Error() string {
+	return fmt.Sprintf("%s after %d attempts: %s", ErrFactoryFailed, e.Attempts, e.Err)
+}
+
+// Unwrap 回传最后一次factory回传的错误
+func (e *GetError) Unwrap() error {
+	return e.Err
+}
+
+// Is 让errors.Is(err, ErrFactoryFailed)成立
+func (e *GetError) Is(target error) bool {
+	return target == ErrFactoryFailed
+}
+
 // Config 连接池相关配置
+// 其中用户设定的方法都在不持有pool锁的情况下调用，可以在方法内再调用Pool的方法
 type Config struct {
-	//连接池中初始化的连接数(需>0、<=MaxCap)
+	//pool的名称，同一个程序有多个pool时用来区分，会出现在Stats与错误信息中
+	Name string
+	//连接池中初始化的连接数(需>=0，MaxCap不为0时需<=MaxCap)
 	InitialCap int
 	//连接池中拥有的最大的连接数(需>=0，若為0表示无限制)
 	MaxCap int
@@ -26,10 +77,210 @@ type Config struct {
 	Factory func() (interface{}, error)
 	//关闭连接的方法
 	Close func(interface{}) error
+	//Close方法回传错误时调用，包含Release及Get丢弃连接时的关闭；不论成功与否该连接都已从pool移除
+	OnCloseError func(conn interface{}, err error)
+	//Release及ReleaseKeeping关闭每个空闲连接前调用，例如送出道别讯息；回传错误时仍会关闭该连接，错误可由ReleaseErrors取得
+	OnRelease func(conn interface{}) error
+	//每次关闭连接后调用，传入连接从建立至今的时间(没有记录的连接为0，见TrackConnections)及关闭原因，用来调整IdleTimeout、MaxLifetime
+	OnConnClose func(age time.Duration, reason CloseReason)
 	//检查连接是否有效的方法
 	Ping func(interface{}) error
+	//factory失败时的重试次数，0表示不重试
+	FactoryRetries int
+	//factory重试前的等待策略，nil表示立即重试
+	Backoff Backoff
+	//Get丢弃超时或不可用的空闲连接时，交给后台协程关闭的队列长度，避免慢的Close拖慢Get；
+	//队列满时直接关闭。0表示不使用后台关闭
+	AsyncCloseQueue int
+	//factory建立连接后立即执行的方法，例如认证、设定session变量，回传错误则关闭该连接，视同factory失败
+	OnNewConn func(interface{}) error
+	//pool第一个成功建立(含通过OnNewConn)的连接调用一次，之后不再调用，例如用来查询服务端的能力
+	//同时建立的其它连接会等它回传后才交出
+	OnFirstConnect func(interface{})
+	//Get从pool中取出空闲连接时检查该连接是否可用，回传false则关闭该连接并改取下一个，没有则新建
+	Validate func(interface{}) bool
+	//Get取出空闲连接时以该连接执行的检查，例如送一个简单的查询或握手，在Validate与ping之后调用；
+	//回传错误则关闭该连接并改取下一个，没有则新建。新建立的连接不检查
+	ValidateOnBorrow func(conn interface{}) error
 	//连接最大空闲时间，當Get時會檢查在pool內是否待超過IdleTimeout，若超過會close再建一個新的回傳
 	IdleTimeout time.Duration
+	//连接从建立起的最长存活时间，与每次放回重新计算的IdleTimeout分开：Get、Put与Maintain遇到超过的连接即关闭。
+	//没有记录建立时间的连接不受此限制。0表示不限制
+	MaxLifetime time.Duration
+	//放回已超过MaxLifetime的连接时，关闭后回传ErrConnExpired而不是nil，让调用者得知连接因存活时间而被关闭
+	StrictLifetime bool
+	//连接存活超过MaxLifetime的此比例后，Get取出时以随存活时间线性增加的机率提早关闭，到MaxLifetime时为必定关闭，
+	//分散同时建立的连接到期的时间。需在0与1之间，其余值表示不提早回收
+	EarlyRecycleFraction float64
+	//设定Ping时，Get取出空闲超过此时间的连接会先ping检查，失败则关闭并改取下一个；刚放回的连接直接使用。
+	//Maintain也只ping空闲超过此时间的连接。0表示Get不检查，Maintain则ping所有空闲连接
+	ValidateIdleThreshold time.Duration
+	//已有连接正在建立时，Get新建连接前先等待其它协程放回连接的最长时间，0表示不等待直接建立
+	CreateWait time.Duration
+	//为true时Get等factory成功后才将已开启连接数加一，factory失败期间不会占用名额而挡住其它Get，
+	//代价是同时建立连接时，成功后名额已被占满的连接会被关闭，该Get改为等待其它连接放回
+	PessimisticCreate bool
+	//GetContext取得连接的最长时间，超过则回传ErrWaitTimeout；ctx的期限较早时以ctx为准。0表示只受ctx限制
+	WaitTimeout time.Duration
+	//Events事件通道的缓冲大小，通道满时丢弃新事件而不阻塞。0表示不送出事件
+	EventBuffer int
+	//使用中连接数占MaxCap的百分比(0~100)告警门槛，向上越过时调用OnSaturationCrossed，MaxCap为0时不检查
+	SaturationThreshold float64
+	//使用中连接百分比向上越过SaturationThreshold时调用，降回门槛以下后再次越过才会再调用
+	OnSaturationCrossed func(pct float64)
+	//Get取出的空闲连接未通过Validate或ping检查时，改以此方法交出该连接与原因而不关闭，方便保留下来诊断；
+	//连接交出后即不属于pool，由此方法负责关闭。nil表示直接关闭
+	Quarantine func(conn interface{}, reason string)
+	//连接交给等待的请求时该请求刚好放弃等待(取消或超时)，默认直接放回pool；
+	//为true时先以Validate与Ping(有设定的)检查，未通过则关闭该连接
+	ValidateAbandoned bool
+	//连接数已满时最多阻塞等待的Get数，超过时Get立即回传ErrTooManyWaiters，拒绝次数见Stats.WaitRejected。0表示无限制
+	MaxWaiters int
+	//设定时Get从空闲连接中取出IdleLess认为最小(a比b好时回传true)的连接，例如较新的连接或绑定特定资源的连接；
+	//每次Get持有pool锁逐一比较所有空闲连接，成本与空闲连接数成正比，MaxCap为0时可用MaxIdle限制放回后保留的空闲连接数；
+	//在持有pool锁时调用，不可调用pool的方法。nil表示取最早放回的连接
+	IdleLess func(a, b interface{}) bool
+	//连接被Put放回时以它从Get取出到放回的时间调用，用来找出占用连接过久的调用者，统计见Stats.InUseDuration。nil表示不调用
+	OnReturnDuration func(d time.Duration)
+	//大于0时Put只将连接送入此长度的队列后立即回传nil，由后台协程整批放回，每批只取一次pool锁；
+	//队列满时Put阻塞，放回的错误不会回传给Put。多了一次channel传递，是否较快视负载而定，可用BenchmarkPutCoalesced比较。
+	//0表示Put直接放回
+	PutCoalesceQueue int
+	//pool最多建立的连接总数(含初始化与已关闭的连接)，用完后只重用已有的连接，
+	//没有空闲连接且未达MaxCap时Get回传ErrQuotaExhausted。0表示无限制
+	MaxTotalCreated int
+	//保留的最大空闲连接数，空闲连接已达此数时Put放回的连接直接关闭(CloseExcess)，
+	//InitialCap多于MaxIdle时多出的空闲连接由Maintain修剪。0表示不限制
+	MaxIdle int
+	//Get取得连接的策略，默认CachedOrNewConn，可用SetStrategy切换
+	Strategy PolicyType
+	//连接关闭时以该连接被Get取出的次数调用，可用来观察连接的重用次数，统计见Stats.ReuseCount。nil表示不调用
+	OnReuseCount func(uses int)
+	//为true时记录每个连接的建立时间、取出次数与取出时间，ConnectionAges才能回报建立时间与使用中的连接；
+	//设定MaxLifetime、OnConnClose、OnReuseCount或OnReturnDuration时自动记录。
+	//只有指针与chan类型的连接以地址记录，其它类型的连接没有记录：不受MaxLifetime限制，也不计入上述回调与统计
+	TrackConnections bool
+	//多个pool共用的后端健康状态，factory(含重试)建立连接失败、成功时以Name回报，BalancedPool据此避开可疑的后端。nil表示不回报
+	Health *HealthRegistry
+	//关闭仍可用的连接(超过IdleTimeout、MaxLifetime或MaxIdle等连接数限制)时改用此方法，例如先送出协议的quit再断线；
+	//用户Close、检查失败与Release仍使用Close。nil表示都使用Close
+	GracefulClose func(interface{}) error
+	//只用于NewCountingTCPPool：大于0时对新建立的*net.TCPConn启用TCP keepalive并以此为探测间隔，其它类型的连接不处理。0表示使用系统默认
+	TCPKeepAlive time.Duration
+}
+
+// PolicyType Get取得连接的策略
+type PolicyType int32
+
+const (
+	CachedOrNewConn PolicyType = 0 //有可用空闲连接则优先使用，没有则创建
+	AlwaysNewConn   PolicyType = 1 //不管有没有空闲连接都重新创建，有空闲连接时先关闭最早放回的一个
+)
+
+// MaintainResult Maintain各项处理的数量
+type MaintainResult struct {
+	Pinged  int //ping检查的空闲连接数
+	Removed int //ping失败而关闭的连接数
+	Reaped  int //超过IdleTimeout而关闭的连接数
+	Trimmed int //超过MaxIdle而关闭的连接数
+	Expired int //超过MaxLifetime而关闭的连接数
+}
+
+// Option Clone时修改配置的方法
+type Option func(*Config)
+
+// Stats 连接池状态统计，可直接编码为JSON，耗时以纳秒表示
+type Stats struct {
+	Name           string         `json:"name"`            //pool的名称
+	MaxOpen        int            `json:"max_open"`        //最大连接数，0表示无限制
+	NumOpen        int            `json:"num_open"`        //已建立连接或等待建立连接数
+	Idle           int            `json:"idle"`            //空闲连接数
+	Parked         int            `json:"parked"`          //被Park暂时移出轮替的连接数
+	InUse          int            `json:"in_use"`          //使用中的连接数
+	BusyPercent    float64        `json:"busy_percent"`    //使用中连接数占MaxOpen的百分比，MaxOpen为0时为0
+	WaitQueueLen   int            `json:"wait_queue_len"`  //目前阻塞等待连接的请求数
+	Created        int64          `json:"created"`         //累计建立的连接数，含初始化、Get建立的连接
+	Closed         int64          `json:"closed"`          //累计关闭的连接数，含Close、Release及Get丢弃的连接
+	ClosedIdle     int64          `json:"closed_idle"`     //超过IdleTimeout而关闭的连接数
+	ClosedLifetime int64          `json:"closed_lifetime"` //超过MaxLifetime而关闭的连接数
+	Quarantined    int64          `json:"quarantined"`     //交给Quarantine而离开pool的连接数，不计入Closed；没有正在建立的连接时Created-Closed-Quarantined等于NumOpen
+	EventsDropped  int64          `json:"events_dropped"`  //事件通道满而丢弃的事件数
+	WaitRejected   int64          `json:"wait_rejected"`   //等待队列达到MaxWaiters而被拒绝的Get数
+	FactoryLatency FactoryLatency `json:"factory_latency"` //factory调用耗时统计
+	ReuseCount     ReuseCount     `json:"reuse_count"`     //已关闭连接被取出次数的统计，只有设定OnReuseCount时才计算
+	InUseDuration  InUseDuration  `json:"in_use_duration"` //连接取出到放回时间的统计，只有设定OnReturnDuration时才计算
+	BytesRead      int64          `json:"bytes_read"`      //累计读取的字节数，只有NewCountingTCPPool建立的pool会计算
+	BytesWritten   int64          `json:"bytes_written"`   //累计写入的字节数，只有NewCountingTCPPool建立的pool会计算
+}
+
+// FactoryLatency factory调用耗时统计，每次调用(含重试、成功与失败)都计入，JSON中的耗时以纳秒表示
+type FactoryLatency struct {
+	Count int64         `json:"count"`  //factory调用次数
+	Min   time.Duration `json:"min_ns"` //最短耗时
+	Avg   time.Duration `json:"avg_ns"` //平均耗时
+	Max   time.Duration `json:"max_ns"` //最长耗时
+}
+
+// ReuseCount 已关闭连接被Get取出次数的统计
+type ReuseCount struct {
+	Count int64   `json:"count"` //计入统计的已关闭连接数
+	Avg   float64 `json:"avg"`   //平均取出次数
+	Max   int     `json:"max"`   //最多取出次数
+}
+
+// InUseDuration 连接从Get取出到Put放回的时间统计，JSON中的时间以纳秒表示
+type InUseDuration struct {
+	Count int64         `json:"count"`  //计入统计的放回次数
+	Avg   time.Duration `json:"avg_ns"` //平均使用时间
+	Max   time.Duration `json:"max_ns"` //最长使用时间
+}
+
+// ConnAge ConnectionAges回传的单一连接状况
+type ConnAge struct {
+	Created  time.Time     //建立时间，没有记录时为零值
+	LastUsed time.Time     //最后放回的时间，使用中的连接为零值
+	Idle     time.Duration //已闲置的时间，使用中的连接为0
+	InUse    bool          //是否已被取出使用中
+	Parked   bool          //是否被Park暂时移出轮替
+}
+
+// CloseReason 连接被pool关闭的原因
+type CloseReason int
+
+const (
+	CloseUser     CloseReason = iota //调用者以Close、Invalidate关闭
+	CloseIdle                        //闲置超过IdleTimeout
+	CloseLifetime                    //存活超过MaxLifetime
+	CloseReset                       //连接不可用：ping、Validate、ValidateOnBorrow或OnNewConn检查失败
+	CloseRelease                     //pool被Release、Drain、ReleaseKeeping，或连接放回已关闭、排空中的pool
+	CloseExcess                      //超过MaxCap、MaxIdle等连接数限制
+)
+
+// WakeReason 阻塞等待的Get被唤醒的原因
+type WakeReason int
+
+const (
+	WakeNone       WakeReason = iota //没有阻塞等待
+	WakeServed                       //取得其它协程放回或空出名额后建立的连接
+	WakePoolClosed                   //pool被Release
+	WakeCanceled                     //被CancelWaiters取消
+)
+
+// TryReason GetTryReason回传的结果原因
+type TryReason int
+
+const (
+	TryAvailable TryReason = iota //取得连接
+	TrySaturated                  //连接数已达最大限制，回传nil连接
+	TryClosed                     //pool已关闭
+	TryDraining                   //pool在排空状态
+	TryFailed                     //建立新连接失败
+)
+
+// GetInfo GetWithInfo回传的额外资讯
+type GetInfo struct {
+	Waited     bool       //是否曾阻塞等待
+	WakeReason WakeReason //被唤醒的原因
 }
 
 // Pool 基本方法
@@ -38,11 +289,81 @@ type Pool interface {
 
 	GetTry() (interface{}, error)
 
+	GetTryReason() (interface{}, TryReason, error)
+
+	TryN(func(interface{}) bool) (interface{}, error)
+
+	GetOrError() (interface{}, error)
+
+	GetWithInfo() (interface{}, GetInfo, error)
+
+	GetWithCancel(<-chan struct{}) (interface{}, error)
+
+	GetWithBudget(time.Duration) (interface{}, error)
+
+	GetContext(context.Context) (interface{}, error)
+
+	GetBatch(n int, timeout time.Duration) ([]interface{}, error)
+
+	WaitReady(context.Context) error
+
 	Put(interface{}) error
 
+	PutAll([]interface{}) error
+
+	PutSync(interface{}) error
+
+	PutFresh(interface{}) error
+
 	Ping(interface{}) error
 
 	Close(interface{}) error
 
+	SetIdleTimeout(time.Duration)
+
+	SetMaxLifetime(time.Duration)
+
+	SetStrategy(PolicyType)
+
+	SetFactory(func() (interface{}, error)) error
+
+	Clone(...Option) (Pool, error)
+
+	IsClosed() bool
+
+	Stats() Stats
+
+	StatsJSON() ([]byte, error)
+
+	ConnectionAges() []ConnAge
+
+	Warm(int) error
+
+	Park(func(interface{}) bool) int
+
+	Unpark()
+
+	GetIf(func(interface{}) bool) (interface{}, error)
+
+	Borrow() (interface{}, error)
+
+	Return(interface{}) error
+
+	Invalidate(interface{}) error
+
+	Drain()
+
+	CancelWaiters(error) int
+
+	Events() <-chan PoolEvent
+
+	CapacityAvailable() <-chan struct{}
+
+	Maintain() MaintainResult
+
 	Release()
+
+	ReleaseErrors() []error
+
+	ReleaseKeeping(n int, d time.Duration)
 }
@@ -1,11 +1,18 @@
 package pool
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
 	"os"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -33,6 +40,27 @@ func server() {
 	}
 }
 
+// fakeConn 测试用的假连接，不需要建立真实的网络连接
+type fakeConn struct {
+	id     int32
+	closed int32
+}
+
+// newFakeConfig 回传一个使用fakeConn的Config，created记录factory建立的连接数
+func newFakeConfig(initialCap, maxCap int, created *int32) *Config {
+	return &Config{
+		InitialCap: initialCap,
+		MaxCap:     maxCap,
+		Factory: func() (interface{}, error) {
+			return &fakeConn{id: atomic.AddInt32(created, 1)}, nil
+		},
+		Close: func(v interface{}) error {
+			atomic.StoreInt32(&v.(*fakeConn).closed, 1)
+			return nil
+		},
+	}
+}
+
 func TestNewPool(t *testing.T) {
 	poolConfig := &Config{
 		InitialCap: 1,
@@ -126,6 +154,3301 @@ func TestGetPub(t *testing.T) {
 	}
 }
 
+func TestValidate(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(2, 5, &created)
+	poolConfig.Validate = func(v interface{}) bool { return v.(*fakeConn).id != 1 }
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	cp := p.(*channelPool)
+	first := cp.freeConn[0].conn.(*fakeConn)
+
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(*fakeConn) == first {
+		t.Fatal("Get returned the connection rejected by Validate")
+	}
+	if atomic.LoadInt32(&first.closed) != 1 {
+		t.Error("rejected connection was not closed")
+	}
+	if cp.numOpen != 1 {
+		t.Errorf("numOpen = %d, want 1", cp.numOpen)
+	}
+}
+
+func TestGetOrError(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(0, 2, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.GetOrError(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := p.GetOrError(); err != ErrPoolExhausted || v != nil {
+		t.Errorf("GetOrError = (%v, %v), want (nil, ErrPoolExhausted)", v, err)
+	}
+	if v, err := p.GetTry(); err != nil || v != nil {
+		t.Errorf("GetTry = (%v, %v), want (nil, nil)", v, err)
+	}
+}
+
+func TestSetIdleTimeout(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 2, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	first := p.(*channelPool).freeConn[0].conn.(*fakeConn)
+	p.SetIdleTimeout(10 * time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(*fakeConn) == first {
+		t.Fatal("Get returned a connection idle longer than IdleTimeout")
+	}
+	if atomic.LoadInt32(&first.closed) != 1 {
+		t.Error("idle connection was not closed")
+	}
+}
+
+func TestSetMaxLifetime(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(0, 2, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	//设定前建立的连接没有建立时间，不受限制
+	old, _ := p.Get()
+	p.SetMaxLifetime(10 * time.Millisecond)
+	v, _ := p.Get()
+	time.Sleep(20 * time.Millisecond)
+	p.Put(old)
+	p.Put(v)
+	if atomic.LoadInt32(&old.(*fakeConn).closed) != 0 {
+		t.Error("connection created before SetMaxLifetime was closed")
+	}
+	if atomic.LoadInt32(&v.(*fakeConn).closed) != 1 {
+		t.Error("connection older than the new MaxLifetime was returned to the pool")
+	}
+	if st := p.Stats(); st.ClosedLifetime != 1 || st.Idle != 1 {
+		t.Errorf("stats = %+v, want 1 connection closed for its lifetime", st)
+	}
+}
+
+func TestPutFresh(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 2, &created)
+	poolConfig.MaxLifetime = 20 * time.Millisecond
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	renewed, _ := p.Get()
+	stale, _ := p.Get()
+	time.Sleep(30 * time.Millisecond)
+
+	if err := p.PutFresh(renewed); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(stale)
+	if atomic.LoadInt32(&renewed.(*fakeConn).closed) != 0 {
+		t.Error("PutFresh closed a connection past MaxLifetime")
+	}
+	if atomic.LoadInt32(&stale.(*fakeConn).closed) != 1 {
+		t.Error("Put kept a connection past MaxLifetime")
+	}
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != renewed {
+		t.Errorf("Get = connection %d, want the renewed connection", v.(*fakeConn).id)
+	}
+	p.Put(v)
+	if err := p.PutFresh(nil); err != ErrConnIsNil {
+		t.Errorf("PutFresh(nil) = %v, want ErrConnIsNil", err)
+	}
+}
+
+// waitForWaiters 等待pool中阻塞的Get请求数达到n
+func waitForWaiters(t *testing.T, cp *channelPool, n int) {
+	for i := 0; i < 100; i++ {
+		cp.Lock()
+		c := len(cp.waitingQueue)
+		cp.Unlock()
+		if c == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("waitingQueue never reached %d", n)
+}
+
+func TestPutAll(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(0, 3, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	cp := p.(*channelPool)
+
+	conns := make([]interface{}, 0, 3)
+	for i := 0; i < 3; i++ {
+		v, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, v)
+	}
+
+	got := make(chan interface{})
+	go func() {
+		v, _ := p.Get()
+		got <- v
+	}()
+	waitForWaiters(t, cp, 1)
+
+	err = p.PutAll(append(conns, nil))
+	if errs, ok := err.(MultiError); !ok || len(errs) != 1 || errs[0] != ErrConnIsNil {
+		t.Errorf("PutAll error = %v, want MultiError{ErrConnIsNil}", err)
+	}
+	if v := <-got; v != conns[0] {
+		t.Errorf("waiter got %v, want %v", v, conns[0])
+	}
+	if n := len(cp.freeConn); n != 2 {
+		t.Errorf("len(freeConn) = %d, want 2", n)
+	}
+}
+
+func TestIsClosed(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 2, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IsClosed() {
+		t.Error("IsClosed = true before Release")
+	}
+	p.Release()
+	if !p.IsClosed() {
+		t.Error("IsClosed = false after Release")
+	}
+}
+
+// checkInvariants 测试用的检查方法，在锁内检查pool内部状态是否一致
+// inUse为测试中已取出尚未归还的连接
+func (cp *channelPool) checkInvariants(inUse *sync.Map) error {
+	cp.Lock()
+	defer cp.Unlock()
+	if cp.numOpen < len(cp.freeConn) {
+		return fmt.Errorf("numOpen %d < len(freeConn) %d", cp.numOpen, len(cp.freeConn))
+	}
+	if cp.maxOpen > 0 && cp.numOpen > cp.maxOpen {
+		return fmt.Errorf("numOpen %d > maxOpen %d", cp.numOpen, cp.maxOpen)
+	}
+	seen := make(map[interface{}]bool, len(cp.freeConn))
+	for _, ic := range cp.freeConn {
+		if seen[ic.conn] {
+			return fmt.Errorf("connection %v appears twice in freeConn", ic.conn)
+		}
+		seen[ic.conn] = true
+		if _, ok := inUse.Load(ic.conn); ok {
+			return fmt.Errorf("connection %v is both idle and in use", ic.conn)
+		}
+	}
+	return nil
+}
+
+func TestInvariants(t *testing.T) {
+	const workers = 8
+	const ops = 500
+
+	var created, closed int32
+	poolConfig := newFakeConfig(2, 4, &created)
+	poolConfig.Close = func(v interface{}) error {
+		if !atomic.CompareAndSwapInt32(&v.(*fakeConn).closed, 0, 1) {
+			t.Errorf("connection %v closed twice", v)
+		}
+		atomic.AddInt32(&closed, 1)
+		return nil
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := p.(*channelPool)
+
+	var inUse sync.Map
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			var held interface{}
+			for i := 0; i < ops; i++ {
+				if held == nil {
+					var v interface{}
+					if r.Intn(2) == 0 {
+						v, _ = p.Get()
+					} else {
+						v, _ = p.GetTry()
+					}
+					if v != nil {
+						inUse.Store(v, true)
+						held = v
+					}
+				} else {
+					inUse.Delete(held)
+					if r.Intn(4) == 0 {
+						p.Close(held)
+					} else {
+						p.Put(held)
+					}
+					held = nil
+				}
+				if err := cp.checkInvariants(&inUse); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			if held != nil {
+				inUse.Delete(held)
+				p.Put(held)
+			}
+		}(int64(w))
+	}
+
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		p.Release()
+		close(released)
+	}()
+	wg.Wait()
+	<-released
+
+	if cp.numOpen != 0 {
+		t.Errorf("numOpen = %d after Release, want 0", cp.numOpen)
+	}
+	if c, d := atomic.LoadInt32(&created), atomic.LoadInt32(&closed); c != d {
+		t.Errorf("created %d connections but closed %d", c, d)
+	}
+}
+
+func TestCreateWait(t *testing.T) {
+	const m = 10
+	var created int32
+	poolConfig := newFakeConfig(0, 0, &created)
+	factory := poolConfig.Factory
+	poolConfig.Factory = func() (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		return factory()
+	}
+	poolConfig.CreateWait = time.Second
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	var wg sync.WaitGroup
+	for i := 0; i < m; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := p.Get()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+			p.Put(v)
+		}()
+	}
+	wg.Wait()
+
+	if c := atomic.LoadInt32(&created); c >= m {
+		t.Errorf("created %d connections for %d concurrent Gets", c, m)
+	}
+}
+
+func TestStatsWaitQueueLen(t *testing.T) {
+	const waiters = 3
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Get()
+		}()
+	}
+	waitForWaiters(t, p.(*channelPool), waiters)
+
+	st := p.Stats()
+	if st.WaitQueueLen != waiters {
+		t.Errorf("WaitQueueLen = %d, want %d", st.WaitQueueLen, waiters)
+	}
+	if st.InUse != 1 || st.Idle != 0 {
+		t.Errorf("InUse = %d, Idle = %d, want 1, 0", st.InUse, st.Idle)
+	}
+
+	p.Release()
+	wg.Wait()
+	if n := p.Stats().WaitQueueLen; n != 0 {
+		t.Errorf("WaitQueueLen = %d after Release, want 0", n)
+	}
+}
+
+func TestBorrowReturn(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	v, err := p.Borrow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Return(v); err != nil {
+		t.Fatal(err)
+	}
+	w, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != v {
+		t.Errorf("Get after Return = %v, want %v", w, v)
+	}
+	if err := p.Invalidate(w); err != nil {
+		t.Fatal(err)
+	}
+	if st := p.Stats(); st.NumOpen != 0 {
+		t.Errorf("NumOpen = %d after Invalidate, want 0", st.NumOpen)
+	}
+}
+
+func TestOnNewConn(t *testing.T) {
+	var created int32
+	var last *fakeConn
+	errSetup := fmt.Errorf("setup failed")
+	poolConfig := newFakeConfig(0, 1, &created)
+	poolConfig.OnNewConn = func(v interface{}) error {
+		last = v.(*fakeConn)
+		return errSetup
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	if _, err := p.Get(); err != errSetup {
+		t.Errorf("Get error = %v, want %v", err, errSetup)
+	}
+	if last == nil || atomic.LoadInt32(&last.closed) != 1 {
+		t.Error("connection rejected by OnNewConn was not closed")
+	}
+	if st := p.Stats(); st.NumOpen != 0 {
+		t.Errorf("NumOpen = %d, want 0", st.NumOpen)
+	}
+
+	poolConfig.InitialCap = 1
+	if _, err := NewPool(poolConfig); err == nil {
+		t.Error("NewPool succeeded although OnNewConn failed during prefill")
+	}
+}
+
+func TestGetWithCancel(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	cp := p.(*channelPool)
+
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := p.GetWithCancel(cancel)
+		done <- err
+	}()
+	waitForWaiters(t, cp, 1)
+	close(cancel)
+	if err := <-done; err != ErrCanceled {
+		t.Errorf("GetWithCancel error = %v, want ErrCanceled", err)
+	}
+	if n := p.Stats().WaitQueueLen; n != 0 {
+		t.Errorf("WaitQueueLen = %d after cancel, want 0", n)
+	}
+
+	//cancel与Put同时发生时，连接不可遗失
+	for i := 0; i < 100; i++ {
+		cancel := make(chan struct{})
+		go func() {
+			w, err := p.GetWithCancel(cancel)
+			if err == nil {
+				p.Put(w)
+			}
+			done <- err
+		}()
+		waitForWaiters(t, cp, 1)
+		go close(cancel)
+		p.Put(v)
+		<-done
+		if v, err = p.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := p.Stats(); st.NumOpen != 1 || st.WaitQueueLen != 0 {
+		t.Errorf("NumOpen = %d, WaitQueueLen = %d, want 1, 0", st.NumOpen, st.WaitQueueLen)
+	}
+}
+
+func TestStatsCreatedClosed(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(2, 4, &created)
+	poolConfig.Validate = func(v interface{}) bool { return v.(*fakeConn).id != 2 }
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(step string) {
+		st := p.Stats()
+		if st.Created-st.Closed != int64(st.NumOpen) {
+			t.Errorf("%s: Created %d - Closed %d != NumOpen %d", step, st.Created, st.Closed, st.NumOpen)
+		}
+	}
+	check("NewPool")
+	a, _ := p.Get()
+	b, _ := p.Get()
+	c, _ := p.Get()
+	check("Get")
+	p.Put(a)
+	p.Put(b)
+	check("Put")
+	p.Close(c)
+	check("Close")
+	p.Release()
+	check("Release")
+
+	if st := p.Stats(); st.Created != int64(atomic.LoadInt32(&created)) {
+		t.Errorf("Created = %d, want %d", st.Created, created)
+	}
+}
+
+func TestNeverExceedMaxCap(t *testing.T) {
+	const maxCap = 3
+	var created int32
+	poolConfig := newFakeConfig(0, maxCap, &created)
+	factory := poolConfig.Factory
+	poolConfig.Factory = func() (interface{}, error) {
+		time.Sleep(time.Millisecond)
+		return factory()
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				v, err := p.Get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if st := p.Stats(); st.NumOpen > maxCap {
+					t.Errorf("NumOpen = %d, want <= %d", st.NumOpen, maxCap)
+				}
+				p.Put(v)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c := atomic.LoadInt32(&created); c > maxCap {
+		t.Errorf("created %d connections, want <= %d", c, maxCap)
+	}
+}
+
+func TestHooksReenterPool(t *testing.T) {
+	var created int32
+	var p Pool
+	reenter := func() {
+		if p != nil {
+			p.Stats()
+			p.IsClosed()
+		}
+	}
+	poolConfig := newFakeConfig(2, 2, &created)
+	closeConn := poolConfig.Close
+	poolConfig.Close = func(v interface{}) error {
+		reenter()
+		return closeConn(v)
+	}
+	poolConfig.OnNewConn = func(v interface{}) error {
+		reenter()
+		return nil
+	}
+	poolConfig.Validate = func(v interface{}) bool {
+		reenter()
+		return v.(*fakeConn).id != 1
+	}
+	poolConfig.IdleTimeout = time.Hour
+	var err error
+	if p, err = NewPool(poolConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		v, _ := p.Get()
+		w, _ := p.Get()
+		p.Put(v)
+		p.Close(w)
+		p.Release()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock: hook calling back into the pool never returned")
+	}
+}
+
+func TestParkUnpark(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(2, 2, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	if n := p.Park(func(v interface{}) bool { return v.(*fakeConn).id == 1 }); n != 1 {
+		t.Fatalf("Park = %d, want 1", n)
+	}
+	if st := p.Stats(); st.Parked != 1 || st.Idle != 1 || st.NumOpen != 2 {
+		t.Errorf("Parked = %d, Idle = %d, NumOpen = %d, want 1, 1, 2", st.Parked, st.Idle, st.NumOpen)
+	}
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(*fakeConn).id == 1 {
+		t.Error("Get returned a parked connection")
+	}
+	if w, _ := p.GetTry(); w != nil {
+		t.Errorf("GetTry = %v, want nil while the only other connection is parked", w)
+	}
+	p.Put(v)
+
+	p.Unpark()
+	found := false
+	for i := 0; i < 2; i++ {
+		w, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.(*fakeConn).id == 1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("unparked connection was not available to Get")
+	}
+}
+
+func TestGetWithBudget(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 1, &created)
+	factory := poolConfig.Factory
+	poolConfig.Factory = func() (interface{}, error) {
+		time.Sleep(100 * time.Millisecond)
+		return factory()
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	start := time.Now()
+	if _, err := p.GetWithBudget(20 * time.Millisecond); err != ErrBudgetExceeded {
+		t.Errorf("GetWithBudget error = %v, want ErrBudgetExceeded", err)
+	}
+	if d := time.Since(start); d > 80*time.Millisecond {
+		t.Errorf("GetWithBudget took %v, want about 20ms", d)
+	}
+
+	//慢的factory完成后，建立的连接要被关闭，不能遗失
+	time.Sleep(150 * time.Millisecond)
+	st := p.Stats()
+	if st.NumOpen != 0 || st.Created != 1 || st.Closed != 1 {
+		t.Errorf("NumOpen = %d, Created = %d, Closed = %d, want 0, 1, 1", st.NumOpen, st.Created, st.Closed)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 5, &created)
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	if err := p.Warm(3); err != nil {
+		t.Fatal(err)
+	}
+	if st := p.Stats(); st.Idle != 3 || st.NumOpen != 3 {
+		t.Errorf("Idle = %d, NumOpen = %d, want 3, 3", st.Idle, st.NumOpen)
+	}
+	if err := p.Warm(10); err != nil {
+		t.Fatal(err)
+	}
+	if st := p.Stats(); st.Idle != 5 || st.NumOpen != 5 {
+		t.Errorf("Idle = %d, NumOpen = %d after warming past MaxCap, want 5, 5", st.Idle, st.NumOpen)
+	}
+}
+
+func TestGetWithInfo(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := p.(*channelPool)
+
+	v, info, err := p.GetWithInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Waited || info.WakeReason != WakeNone {
+		t.Errorf("info = %+v for a free connection, want zero value", info)
+	}
+
+	type result struct {
+		info GetInfo
+		err  error
+	}
+	done := make(chan result)
+	get := func() {
+		_, info, err := p.GetWithInfo()
+		done <- result{info, err}
+	}
+
+	go get()
+	waitForWaiters(t, cp, 1)
+	p.Put(v)
+	if r := <-done; r.err != nil || r.info.WakeReason != WakeServed {
+		t.Errorf("after Put: WakeReason = %v, err = %v, want WakeServed, nil", r.info.WakeReason, r.err)
+	}
+
+	go get()
+	waitForWaiters(t, cp, 1)
+	p.Release()
+	if r := <-done; r.err != ErrPoolClosed || r.info.WakeReason != WakePoolClosed {
+		t.Errorf("after Release: WakeReason = %v, err = %v, want WakePoolClosed, ErrPoolClosed", r.info.WakeReason, r.err)
+	}
+}
+
+func TestUnlimitedMaxCap(t *testing.T) {
+	const n = 20
+	var created int32
+	p, err := NewPool(newFakeConfig(0, 0, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	conns := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := p.GetOrError()
+		if err != nil {
+			t.Fatalf("GetOrError #%d error = %v, want nil with MaxCap 0", i, err)
+		}
+		conns = append(conns, v)
+	}
+	if err := p.PutAll(conns); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Warm(5); err != nil {
+		t.Fatal(err)
+	}
+	st := p.Stats()
+	if st.MaxOpen != 0 || st.NumOpen != n+5 || st.Idle != n+5 || st.WaitQueueLen != 0 {
+		t.Errorf("Stats = %+v, want MaxOpen 0, NumOpen %d, Idle %d, WaitQueueLen 0", st, n+5, n+5)
+	}
+}
+
+func TestInitialCapWithUnlimitedMaxCap(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(5, 0, &created))
+	if err != nil {
+		t.Fatalf("NewPool with InitialCap 5, MaxCap 0 error = %v, want nil", err)
+	}
+	defer p.Release()
+	if st := p.Stats(); st.NumOpen != 5 || st.Idle != 5 || atomic.LoadInt32(&created) != 5 {
+		t.Errorf("Stats = %+v, created = %d, want 5 prefilled idle connections", st, created)
+	}
+}
+
+func TestPessimisticCreate(t *testing.T) {
+	var created, calls int32
+	poolConfig := newFakeConfig(0, 1, &created)
+	factory := poolConfig.Factory
+	poolConfig.Factory = func() (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+			return nil, fmt.Errorf("backend down")
+		}
+		return factory()
+	}
+	poolConfig.PessimisticCreate = true
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	failed := make(chan error)
+	go func() {
+		_, err := p.Get()
+		failed <- err
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("peer Get blocked %v behind a failing factory call", d)
+	}
+	if err := <-failed; err == nil {
+		t.Error("first Get succeeded, want the factory error")
+	}
+	if st := p.Stats(); st.NumOpen != 1 {
+		t.Errorf("NumOpen = %d, want 1", st.NumOpen)
+	}
+}
+
+func TestPessimisticCreateRespectsMaxCap(t *testing.T) {
+	const n = 3
+	var created, entered int32
+	poolConfig := newFakeConfig(0, 1, &created)
+	factory := poolConfig.Factory
+	//等所有Get都进入factory才一起回传，建立期间不占名额，所以会同时建立n个连接
+	poolConfig.Factory = func() (interface{}, error) {
+		atomic.AddInt32(&entered, 1)
+		for i := 0; i < 1000 && atomic.LoadInt32(&entered) < n; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		return factory()
+	}
+	poolConfig.PessimisticCreate = true
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := p.Get()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := p.Put(v); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	//多建立的连接被关闭，名额已满的Get改为等待放回的连接
+	if st := p.Stats(); st.NumOpen != 1 || st.Idle != 1 || st.Closed != int64(atomic.LoadInt32(&created))-1 {
+		t.Errorf("stats = %+v, created = %d, want one open idle connection and the extras closed", st, created)
+	}
+	if v, err := p.GetTry(); v == nil || err != nil {
+		t.Errorf("GetTry = (%v, %v), want the pooled connection", v, err)
+	}
+}
+
+func TestPutClosesOverMaxCap(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(0, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	cp := p.(*channelPool)
+	a, _ := p.Get()
+	//模拟已开启连接数超过MaxCap
+	b, c := &fakeConn{id: 100}, &fakeConn{id: 101}
+	cp.Lock()
+	cp.numOpen += 2
+	cp.Unlock()
+
+	if err := p.Put(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PutAll([]interface{}{c, a}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&b.closed) != 1 || atomic.LoadInt32(&c.closed) != 1 {
+		t.Error("connections returned over MaxCap were not closed")
+	}
+	if st := p.Stats(); st.NumOpen != 1 || st.Idle != 1 {
+		t.Errorf("stats = %+v, want NumOpen 1 and Idle 1 after Put recovers", st)
+	}
+	if v, err := p.GetTry(); v != a || err != nil {
+		t.Errorf("GetTry = (%v, %v), want the pooled connection", v, err)
+	}
+}
+
+func TestName(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(1, 1, &created)
+	poolConfig.Name = "orders-db"
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := p.Stats().Name; name != "orders-db" {
+		t.Errorf("Stats().Name = %q, want %q", name, "orders-db")
+	}
+	p.Release()
+
+	poolConfig.Factory = func() (interface{}, error) { return nil, fmt.Errorf("refused") }
+	if _, err := NewPool(poolConfig); err == nil || !strings.Contains(err.Error(), "orders-db") {
+		t.Errorf("NewPool error = %v, want it to mention the pool name", err)
+	}
+}
+
+func TestPutHandsOffToWaiter(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(2, 2, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	cp := p.(*channelPool)
+
+	a, _ := p.Get()
+	b, _ := p.Get()
+	got := make(chan interface{})
+	go func() {
+		v, _ := p.Get()
+		got <- v
+	}()
+	waitForWaiters(t, cp, 1)
+
+	if err := p.Put(a); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-got; v != a {
+		t.Errorf("waiter got %v, want the returned connection %v", v, a)
+	}
+	if st := p.Stats(); st.Idle != 0 || st.WaitQueueLen != 0 {
+		t.Errorf("Idle = %d, WaitQueueLen = %d, want 0, 0", st.Idle, st.WaitQueueLen)
+	}
+	p.Put(b)
+}
+
+func TestTryN(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(3, 3, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	cp := p.(*channelPool)
+	first := cp.freeConn[0].conn.(*fakeConn)
+	second := cp.freeConn[1].conn.(*fakeConn)
+
+	v, err := p.TryN(func(v interface{}) bool { return v.(*fakeConn).id == 3 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == nil || v.(*fakeConn).id != 3 {
+		t.Fatalf("TryN = %v, want connection 3", v)
+	}
+	if atomic.LoadInt32(&first.closed) != 1 || atomic.LoadInt32(&second.closed) != 1 {
+		t.Error("connections failing validation were not closed")
+	}
+
+	//没有通过检查的连接时，连接数未达上限则建立新连接
+	w, err := p.TryN(func(v interface{}) bool { return false })
+	if err != nil || w == nil {
+		t.Errorf("TryN = (%v, %v), want a new connection", w, err)
+	}
+	p.Get()
+	if w, err := p.TryN(func(v interface{}) bool { return true }); w != nil || err != nil {
+		t.Errorf("TryN = (%v, %v) at MaxCap, want (nil, nil)", w, err)
+	}
+}
+
+func TestPutHandOffNeverBlocks(t *testing.T) {
+	const workers = 64
+	var created int32
+	p, err := NewPool(newFakeConfig(0, 4, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	var slowest int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				v, err := p.Get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				start := time.Now()
+				p.Put(v)
+				d := int64(time.Since(start))
+				for {
+					old := atomic.LoadInt64(&slowest)
+					if d <= old || atomic.CompareAndSwapInt64(&slowest, old, d) {
+						break
+					}
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Put to waiter handoff deadlocked")
+	}
+	if d := time.Duration(atomic.LoadInt64(&slowest)); d > time.Second {
+		t.Errorf("slowest Put took %v, want it never to block on the handoff", d)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 3, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := p.Get()
+	b, _ := p.Get()
+	if err := p.Warm(1); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Drain()
+	if st := p.Stats(); st.NumOpen != 2 || st.Idle != 0 {
+		t.Errorf("NumOpen = %d, Idle = %d after Drain, want 2, 0", st.NumOpen, st.Idle)
+	}
+	if _, err := p.Get(); err != ErrDraining {
+		t.Errorf("Get error = %v, want ErrDraining", err)
+	}
+	if _, err := p.GetTry(); err != ErrDraining {
+		t.Errorf("GetTry error = %v, want ErrDraining", err)
+	}
+
+	for i, v := range []interface{}{a, b} {
+		if err := p.Put(v); err != ErrDrainingCloseOnReturn {
+			t.Errorf("Put while draining = %v, want %v", err, ErrDrainingCloseOnReturn)
+		}
+		if atomic.LoadInt32(&v.(*fakeConn).closed) != 1 {
+			t.Errorf("connection returned while draining was not closed")
+		}
+		if n := p.Stats().NumOpen; n != 1-i {
+			t.Errorf("NumOpen = %d after %d Puts, want %d", n, i+1, 1-i)
+		}
+	}
+	p.Release()
+}
+
+func TestGetTryReason(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(0, 2, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if v, reason, err := p.GetTryReason(); v == nil || reason != TryAvailable || err != nil {
+			t.Fatalf("GetTryReason = (%v, %v, %v), want a connection with TryAvailable", v, reason, err)
+		}
+	}
+	if v, reason, err := p.GetTryReason(); v != nil || reason != TrySaturated || err != nil {
+		t.Errorf("GetTryReason = (%v, %v, %v), want (nil, TrySaturated, nil)", v, reason, err)
+	}
+	p.Release()
+	if _, reason, err := p.GetTryReason(); reason != TryClosed || err != ErrPoolClosed {
+		t.Errorf("GetTryReason = (%v, %v) after Release, want (TryClosed, ErrPoolClosed)", reason, err)
+	}
+}
+
+func TestOnCloseError(t *testing.T) {
+	var created int32
+	errClose := fmt.Errorf("connection reset")
+	var reported []interface{}
+	var mu sync.Mutex
+	poolConfig := newFakeConfig(2, 2, &created)
+	poolConfig.Close = func(v interface{}) error { return errClose }
+	poolConfig.OnCloseError = func(v interface{}, err error) {
+		if err != errClose {
+			t.Errorf("OnCloseError err = %v, want %v", err, errClose)
+		}
+		mu.Lock()
+		reported = append(reported, v)
+		mu.Unlock()
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, _ := p.Get()
+	if err := p.Close(v); err != errClose {
+		t.Errorf("Close error = %v, want %v", err, errClose)
+	}
+	if n := p.Stats().NumOpen; n != 1 {
+		t.Errorf("NumOpen = %d after a failed close, want 1", n)
+	}
+	p.Release()
+	if n := p.Stats().NumOpen; n != 0 {
+		t.Errorf("NumOpen = %d after Release, want 0", n)
+	}
+	if len(reported) != 2 || reported[0] != v {
+		t.Errorf("OnCloseError called for %v, want both connections starting with %v", reported, v)
+	}
+}
+
+func TestValidateIdleThreshold(t *testing.T) {
+	var created, pinged int32
+	poolConfig := newFakeConfig(1, 1, &created)
+	poolConfig.Ping = func(v interface{}) error {
+		atomic.AddInt32(&pinged, 1)
+		return nil
+	}
+	poolConfig.ValidateIdleThreshold = 50 * time.Millisecond
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	v, _ := p.Get()
+	p.Put(v)
+	v, _ = p.Get()
+	if n := atomic.LoadInt32(&pinged); n != 0 {
+		t.Errorf("pinged %d times for a freshly returned connection, want 0", n)
+	}
+	p.Put(v)
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&pinged); n != 1 {
+		t.Errorf("pinged %d times for a long-idle connection, want 1", n)
+	}
+}
+
+func TestAsyncClose(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(1, 2, &created)
+	closeConn := poolConfig.Close
+	poolConfig.Close = func(v interface{}) error {
+		time.Sleep(200 * time.Millisecond)
+		return closeConn(v)
+	}
+	poolConfig.IdleTimeout = 10 * time.Millisecond
+	poolConfig.AsyncCloseQueue = 4
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := p.(*channelPool).freeConn[0].conn.(*fakeConn)
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Get took %v, want it not to wait for the slow close", d)
+	}
+	if v.(*fakeConn) == expired {
+		t.Fatal("Get returned the expired connection")
+	}
+	if n := p.Stats().NumOpen; n != 1 {
+		t.Errorf("NumOpen = %d, want 1 right after the expired connection was dropped", n)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if atomic.LoadInt32(&expired.closed) != 1 {
+		t.Error("expired connection was never closed in the background")
+	}
+	p.Put(v)
+	p.Release()
+}
+
+func TestCancelWaiters(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backendDown := errors.New("backend down")
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := p.Get()
+			errs <- err
+		}()
+	}
+	waitForWaiters(t, p.(*channelPool), 3)
+
+	if n := p.CancelWaiters(backendDown); n != 3 {
+		t.Errorf("CancelWaiters = %d, want 3", n)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != backendDown {
+			t.Errorf("Get err = %v, want %v", err, backendDown)
+		}
+	}
+	if p.IsClosed() {
+		t.Error("CancelWaiters closed the pool")
+	}
+	if err := p.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(); err != nil {
+		t.Errorf("Get after CancelWaiters = %v, want nil", err)
+	}
+	p.Release()
+}
+
+func TestGetContext(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(1, 1, &created)
+	poolConfig.WaitTimeout = 50 * time.Millisecond
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//ctx的期限较早
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_, err = p.GetContext(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("GetContext with sooner ctx = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	//WaitTimeout较早
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	start := time.Now()
+	_, err = p.GetContext(ctx)
+	cancel()
+	if err != ErrWaitTimeout {
+		t.Errorf("GetContext with sooner WaitTimeout = %v, want %v", err, ErrWaitTimeout)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("GetContext took %v, want about the WaitTimeout", d)
+	}
+	if n := p.Stats().WaitQueueLen; n != 0 {
+		t.Errorf("WaitQueueLen = %d after timeouts, want 0", n)
+	}
+
+	//连接在两者之前放回
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Put(v)
+	}()
+	got, err := p.GetContext(context.Background())
+	if err != nil {
+		t.Fatalf("GetContext = %v, want the returned connection", err)
+	}
+	if got != v {
+		t.Error("GetContext did not receive the returned connection")
+	}
+	p.Put(got)
+	p.Release()
+}
+
+// TestHandoffRaces Put交给等待者的同时Release或等待者放弃，连接只能落在一处：交给等待者、被关闭或回到freeConn
+func TestHandoffRaces(t *testing.T) {
+	type result struct {
+		conn interface{}
+		err  error
+	}
+	for i := 0; i < 200; i++ {
+		var created int32
+		p, err := NewPool(newFakeConfig(1, 1, &created))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cp := p.(*channelPool)
+		v, _ := p.Get()
+		waiter := make(chan result, 1)
+		go func() {
+			conn, err := p.Get()
+			waiter <- result{conn, err}
+		}()
+		waitForWaiters(t, cp, 1)
+
+		putErr := make(chan error, 1)
+		go func() { putErr <- p.Put(v) }()
+		p.Release()
+		perr := <-putErr
+		r := <-waiter
+		fc := v.(*fakeConn)
+		switch {
+		case r.err == nil:
+			if r.conn != v || perr != nil || atomic.LoadInt32(&fc.closed) != 0 {
+				t.Fatalf("round %d: delivered conn=%v putErr=%v closed=%d", i, r.conn, perr, fc.closed)
+			}
+			if err := p.Put(r.conn); err != ErrPoolClosedAndClose {
+				t.Fatalf("round %d: Put after Release = %v", i, err)
+			}
+		case r.err == ErrPoolClosed:
+			if perr != ErrPoolClosedAndClose {
+				t.Fatalf("round %d: waiter saw close but Put = %v", i, perr)
+			}
+		default:
+			t.Fatalf("round %d: waiter err = %v", i, r.err)
+		}
+		if atomic.LoadInt32(&fc.closed) != 1 || cp.Stats().Closed != 1 || cp.Stats().NumOpen != 0 {
+			t.Fatalf("round %d: stats = %+v, closed = %d", i, cp.Stats(), fc.closed)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		var created int32
+		p, err := NewPool(newFakeConfig(1, 1, &created))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cp := p.(*channelPool)
+		v, _ := p.Get()
+		cancel := make(chan struct{})
+		waiter := make(chan result, 1)
+		go func() {
+			conn, err := p.GetWithCancel(cancel)
+			waiter <- result{conn, err}
+		}()
+		waitForWaiters(t, cp, 1)
+
+		go p.Put(v)
+		close(cancel)
+		r := <-waiter
+		switch r.err {
+		case nil:
+			if r.conn != v {
+				t.Fatalf("round %d: delivered %v, want the put connection", i, r.conn)
+			}
+			p.Put(r.conn)
+		case ErrCanceled:
+		default:
+			t.Fatalf("round %d: waiter err = %v", i, r.err)
+		}
+		for j := 0; j < 100 && cp.Stats().Idle != 1; j++ {
+			time.Sleep(time.Millisecond)
+		}
+		if st := cp.Stats(); st.Idle != 1 || st.NumOpen != 1 || st.Closed != 0 {
+			t.Fatalf("round %d: stats = %+v, want the connection back in freeConn", i, st)
+		}
+		p.Release()
+	}
+}
+
+func TestSupervisorRestartsCloser(t *testing.T) {
+	var created, panics int32
+	poolConfig := newFakeConfig(2, 2, &created)
+	closeConn := poolConfig.Close
+	poolConfig.Close = func(v interface{}) error {
+		closeConn(v)
+		return errors.New("connection reset")
+	}
+	//close的panic会被safeClose转为ErrCloseFailed，改由OnCloseError panic让closer协程中止
+	poolConfig.OnCloseError = func(v interface{}, err error) {
+		if atomic.AddInt32(&panics, 1) == 1 {
+			panic("close error handler failed badly")
+		}
+	}
+	poolConfig.AsyncCloseQueue = 4
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := p.(*channelPool)
+	first := cp.freeConn[0].conn.(*fakeConn)
+	second := cp.freeConn[1].conn.(*fakeConn)
+
+	//第一个连接的OnCloseError会panic，第二个要在closer重新执行后被关闭
+	cp.Lock()
+	cp.discardLocked(first, CloseReset)
+	cp.discardLocked(second, CloseReset)
+	cp.freeConn = nil
+	cp.Unlock()
+	for i := 0; i < 100 && atomic.LoadInt32(&panics) < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&first.closed) != 1 || atomic.LoadInt32(&second.closed) != 1 {
+		t.Fatal("closer did not close both connections")
+	}
+	if n := atomic.LoadInt32(&panics); n != 2 {
+		t.Fatalf("OnCloseError called %d times, want 2: closer did not keep running after a panic", n)
+	}
+	p.Release()
+}
+
+func TestSaturationThreshold(t *testing.T) {
+	var created int32
+	var crossed []float64
+	var mu sync.Mutex
+	poolConfig := newFakeConfig(0, 4, &created)
+	poolConfig.SaturationThreshold = 75
+	poolConfig.OnSaturationCrossed = func(pct float64) {
+		mu.Lock()
+		crossed = append(crossed, pct)
+		mu.Unlock()
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(crossed)
+	}
+
+	var conns []interface{}
+	for i := 0; i < 4; i++ {
+		v, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, v)
+	}
+	if n := calls(); n != 1 || crossed[0] != 75 {
+		t.Fatalf("OnSaturationCrossed calls = %v, want one call at 75", crossed)
+	}
+	if pct := p.Stats().BusyPercent; pct != 100 {
+		t.Errorf("BusyPercent = %v, want 100", pct)
+	}
+
+	//仍在门槛以上不重复调用，降到门槛以下后再次越过才调用
+	p.Put(conns[3])
+	if n := calls(); n != 1 {
+		t.Errorf("calls = %d after staying above the threshold, want 1", n)
+	}
+	p.Put(conns[2])
+	p.Put(conns[1])
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls(); n != 1 {
+		t.Errorf("calls = %d below the threshold, want 1", n)
+	}
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls(); n != 2 {
+		t.Errorf("calls = %d after crossing again, want 2", n)
+	}
+	p.Release()
+}
+
+func TestGetIf(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(3, 3, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	isTwo := func(v interface{}) bool { return v.(*fakeConn).id == 2 }
+
+	v, err := p.GetIf(isTwo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == nil || v.(*fakeConn).id != 2 {
+		t.Fatalf("GetIf = %v, want connection 2", v)
+	}
+	if w, err := p.GetIf(isTwo); w != nil || err != nil {
+		t.Errorf("GetIf with no match = %v, %v, want nil, nil", w, err)
+	}
+	if st := p.Stats(); st.Idle != 2 || st.InUse != 1 || atomic.LoadInt32(&created) != 3 {
+		t.Errorf("stats = %+v, created = %d, want 2 idle, 1 in use, no new connection", st, created)
+	}
+	p.Put(v)
+	p.Release()
+}
+
+func TestGetIfValidate(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(3, 3, &created)
+	poolConfig.Validate = func(v interface{}) bool { return v.(*fakeConn).id != 2 }
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	//连接2未通过Validate而被关闭，改取下一个符合的连接3
+	v, err := p.GetIf(func(v interface{}) bool { return v.(*fakeConn).id >= 2 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == nil || v.(*fakeConn).id != 3 {
+		t.Fatalf("GetIf = %v, want connection 3", v)
+	}
+	if st := p.Stats(); st.NumOpen != 2 || st.Idle != 1 || st.Closed != 1 {
+		t.Errorf("stats = %+v, want the invalid connection closed and connection 1 still idle", st)
+	}
+	if w, err := p.GetIf(func(v interface{}) bool { return v.(*fakeConn).id == 2 }); w != nil || err != nil {
+		t.Errorf("GetIf for the closed connection = %v, %v, want nil, nil", w, err)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(1, 2, &created)
+	poolConfig.Ping = func(v interface{}) error {
+		if v.(*fakeConn).id == 1 {
+			return errors.New("broken pipe")
+		}
+		return nil
+	}
+	poolConfig.ValidateIdleThreshold = time.Millisecond
+	var quarantined interface{}
+	var reason string
+	poolConfig.Quarantine = func(conn interface{}, r string) {
+		quarantined, reason = conn, r
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := p.(*channelPool).freeConn[0].conn.(*fakeConn)
+	time.Sleep(5 * time.Millisecond)
+
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == broken {
+		t.Fatal("Get returned the connection that failed ping")
+	}
+	if quarantined != broken || !strings.Contains(reason, "broken pipe") {
+		t.Errorf("Quarantine got %v with reason %q, want the broken connection and its ping error", quarantined, reason)
+	}
+	if atomic.LoadInt32(&broken.closed) != 0 {
+		t.Error("quarantined connection was closed")
+	}
+	st := p.Stats()
+	if st.NumOpen != 1 || st.Quarantined != 1 || st.Closed != 0 {
+		t.Errorf("stats = %+v, want 1 open and 1 quarantined connection", st)
+	}
+	if n := st.Created - st.Closed - st.Quarantined; n != int64(st.NumOpen) {
+		t.Errorf("Created-Closed-Quarantined = %d, want NumOpen %d", n, st.NumOpen)
+	}
+	p.Put(v)
+	p.Release()
+}
+
+func TestSetFactory(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 3, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const rotated = -1
+	if err := p.SetFactory(func() (interface{}, error) { return &fakeConn{id: rotated}, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetFactory(nil); err != ErrInvalidFactoryFunc {
+		t.Errorf("SetFactory(nil) = %v, want %v", err, ErrInvalidFactoryFunc)
+	}
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := v.(*fakeConn).id; id != rotated {
+		t.Errorf("new connection id = %d, want it from the new factory", id)
+	}
+	//已有的连接继续使用
+	if err := p.Put(old); err != nil {
+		t.Fatal(err)
+	}
+	if w, _ := p.Get(); w != old {
+		t.Error("existing connection was not reused after SetFactory")
+	}
+	p.Release()
+}
+
+func TestPutCoalesce(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 4, &created)
+	poolConfig.PutCoalesceQueue = 8
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				v, err := p.Get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err := p.Put(v); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	//后台协程放回队列中的连接后，所有连接都应回到freeConn
+	for i := 0; i < 100 && p.Stats().InUse != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	st := p.Stats()
+	if st.InUse != 0 || st.NumOpen > 4 || int64(st.Idle) != st.Created {
+		t.Errorf("stats = %+v, want every created connection idle", st)
+	}
+
+	//Release后送入的连接直接关闭
+	v, _ := p.Get()
+	p.Release()
+	if err := p.Put(v); err != ErrPoolClosedAndClose {
+		t.Errorf("Put after Release = %v, want %v", err, ErrPoolClosedAndClose)
+	}
+	if st := p.Stats(); st.NumOpen != 0 || st.Closed != st.Created {
+		t.Errorf("stats after Release = %+v, want every connection closed", st)
+	}
+}
+
+func TestPutAllPanicClosesRest(t *testing.T) {
+	var created, calls int32
+	poolConfig := newFakeConfig(0, 0, &created)
+	poolConfig.MaxLifetime = time.Millisecond
+	//第一次关闭时panic，同一批(例如putter整批放回)其余的连接仍要关闭
+	poolConfig.OnConnClose = func(age time.Duration, reason CloseReason) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("close hook failed")
+		}
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	var conns []interface{}
+	for i := 0; i < 3; i++ {
+		v, _ := p.Get()
+		conns = append(conns, v)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	func() {
+		defer func() {
+			if r := recover(); r != "close hook failed" {
+				t.Errorf("recovered %v, want the hook panic", r)
+			}
+		}()
+		p.PutAll(conns)
+	}()
+	for _, v := range conns {
+		if atomic.LoadInt32(&v.(*fakeConn).closed) != 1 {
+			t.Errorf("connection %d was not closed after the hook panicked", v.(*fakeConn).id)
+		}
+	}
+	if st := p.Stats(); st.NumOpen != 0 || st.Closed != 3 {
+		t.Errorf("stats = %+v, want all 3 connections closed", st)
+	}
+}
+
+func benchmarkPut(b *testing.B, coalesce int) {
+	var created int32
+	poolConfig := newFakeConfig(0, 0, &created)
+	poolConfig.PutCoalesceQueue = coalesce
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			v, err := p.Get()
+			if err != nil {
+				b.Fatal(err)
+			}
+			p.Put(v)
+		}
+	})
+	b.StopTimer()
+	p.Release()
+}
+
+func BenchmarkPut(b *testing.B) {
+	benchmarkPut(b, 0)
+}
+
+func BenchmarkPutCoalesced(b *testing.B) {
+	benchmarkPut(b, 256)
+}
+
+func TestReleaseKeeping(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(4, 4, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ReleaseKeeping(2, 50*time.Millisecond)
+	if st := p.Stats(); st.Idle != 2 || st.NumOpen != 2 || st.Closed != 2 {
+		t.Errorf("stats = %+v, want 2 kept and 2 closed", st)
+	}
+
+	//保留期间仍可取得连接
+	for i := 0; i < 2; i++ {
+		v, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get during the keep window = %v", err)
+		}
+		p.Put(v)
+	}
+	if p.IsClosed() {
+		t.Fatal("pool closed before the keep window ended")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if !p.IsClosed() {
+		t.Error("pool not released after the keep window")
+	}
+	if st := p.Stats(); st.NumOpen != 0 || st.Closed != 4 {
+		t.Errorf("stats after the window = %+v, want every connection closed", st)
+	}
+}
+
+func TestClone(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 2, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone, err := p.Clone(func(c *Config) { c.MaxCap = 5 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := clone.Stats(); st.MaxOpen != 5 || st.Idle != 1 {
+		t.Errorf("clone stats = %+v, want MaxOpen 5 and its own initial connection", st)
+	}
+	if st := p.Stats(); st.MaxOpen != 2 {
+		t.Errorf("original MaxOpen = %d, want 2", st.MaxOpen)
+	}
+
+	//SetStrategy、SetMaxLifetime的修改也带到新的pool
+	p.SetStrategy(AlwaysNewConn)
+	p.SetMaxLifetime(time.Minute)
+	live, err := p.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lcp := live.(*channelPool)
+	if lcp.strategy != AlwaysNewConn || lcp.maxLifetime != time.Minute || lcp.conns == nil {
+		t.Errorf("clone strategy = %v, maxLifetime = %v, want the live settings of the original", lcp.strategy, lcp.maxLifetime)
+	}
+	live.Release()
+	p.SetStrategy(CachedOrNewConn)
+
+	//两个pool的连接互不影响
+	v, err := clone.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := p.Stats(); st.Idle != 1 || st.InUse != 0 {
+		t.Errorf("original stats = %+v after Get on the clone", st)
+	}
+	clone.Release()
+	if p.IsClosed() {
+		t.Error("releasing the clone closed the original")
+	}
+	if w, err := p.Get(); err != nil || w == v {
+		t.Errorf("original Get = %v, %v, want its own connection", w, err)
+	}
+	p.Release()
+}
+
+func TestFactoryReturnedNil(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 1, &created)
+	poolConfig.Factory = func() (interface{}, error) { return nil, nil }
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	v, err := p.Get()
+	if v != nil || !errors.Is(err, ErrFactoryReturnedNil) {
+		t.Errorf("Get = %v, %v, want %v", v, err, ErrFactoryReturnedNil)
+	}
+	if st := p.Stats(); st.NumOpen != 0 || st.Created != 0 {
+		t.Errorf("stats = %+v, want no slot taken and nothing created", st)
+	}
+}
+
+func TestGetErrorAttempts(t *testing.T) {
+	var created int32
+	down := errors.New("connection refused")
+	poolConfig := newFakeConfig(0, 1, &created)
+	poolConfig.Factory = func() (interface{}, error) { return nil, down }
+	poolConfig.FactoryRetries = 2
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	_, err = p.Get()
+	var getErr *GetError
+	if !errors.As(err, &getErr) {
+		t.Fatalf("Get error = %v, want a *GetError", err)
+	}
+	if getErr.Attempts != 3 || getErr.Err != down {
+		t.Errorf("GetError = %+v, want 3 attempts ending with %v", getErr, down)
+	}
+	if !errors.Is(err, ErrFactoryFailed) || !errors.Is(err, down) {
+		t.Errorf("errors.Is(%v) failed for ErrFactoryFailed or the cause", err)
+	}
+	if n := p.Stats().NumOpen; n != 0 {
+		t.Errorf("NumOpen = %d after failed Get, want 0", n)
+	}
+}
+
+func TestFactoryLatency(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 3, &created)
+	factory := poolConfig.Factory
+	poolConfig.Factory = func() (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		return factory()
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := p.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lat := p.Stats().FactoryLatency
+	if lat.Count != 3 {
+		t.Errorf("Count = %d, want 3", lat.Count)
+	}
+	if lat.Min < 20*time.Millisecond || lat.Avg < lat.Min || lat.Max < lat.Avg {
+		t.Errorf("latency = %+v, want min <= avg <= max and min >= 20ms", lat)
+	}
+	if lat.Avg > 60*time.Millisecond {
+		t.Errorf("Avg = %v, want about 20ms", lat.Avg)
+	}
+	p.Release()
+}
+
+func TestMaxTotalCreated(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(1, 3, &created)
+	poolConfig.MaxTotalCreated = 2
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(); err != ErrQuotaExhausted {
+		t.Errorf("Get past the quota = %v, want %v", err, ErrQuotaExhausted)
+	}
+
+	//额度用完后仍可重用已有的连接，关闭的连接不会归还额度
+	p.Put(a)
+	if v, err := p.Get(); err != nil || v != a {
+		t.Errorf("Get = %v, %v, want the reused connection", v, err)
+	}
+	p.Close(b)
+	if _, err := p.Get(); err != ErrQuotaExhausted {
+		t.Errorf("Get after Close = %v, want %v", err, ErrQuotaExhausted)
+	}
+	if n := atomic.LoadInt32(&created); n != 2 {
+		t.Errorf("factory called %d times, want 2", n)
+	}
+	p.Release()
+}
+
+func TestMaintain(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(6, 6, &created)
+	poolConfig.IdleTimeout = time.Minute
+	poolConfig.MaxIdle = 2
+	poolConfig.Ping = func(v interface{}) error {
+		if v.(*fakeConn).id == 3 {
+			return errors.New("dead")
+		}
+		return nil
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := p.(*channelPool)
+	//连接1、2已超过IdleTimeout，连接3 ping失败，其余3个中修剪较早放回的1个
+	cp.Lock()
+	cp.freeConn[0].t = time.Now().Add(-time.Hour)
+	cp.freeConn[1].t = time.Now().Add(-time.Hour)
+	cp.Unlock()
+
+	res := p.Maintain()
+	want := MaintainResult{Pinged: 4, Removed: 1, Reaped: 2, Trimmed: 1}
+	if res != want {
+		t.Errorf("Maintain = %+v, want %+v", res, want)
+	}
+	if st := p.Stats(); st.Idle != 2 || st.NumOpen != 2 || st.Closed != 4 {
+		t.Errorf("stats = %+v, want 2 idle connections left", st)
+	}
+	cp.Lock()
+	ids := []int32{cp.freeConn[0].conn.(*fakeConn).id, cp.freeConn[1].conn.(*fakeConn).id}
+	cp.Unlock()
+	if ids[0] != 5 || ids[1] != 6 {
+		t.Errorf("kept connections %v, want [5 6]", ids)
+	}
+	p.Release()
+}
+
+func TestMaintainValidateIdleThreshold(t *testing.T) {
+	var created int32
+	var mu sync.Mutex
+	var pinged []int32
+	poolConfig := newFakeConfig(4, 4, &created)
+	poolConfig.ValidateIdleThreshold = time.Minute
+	poolConfig.Ping = func(v interface{}) error {
+		mu.Lock()
+		pinged = append(pinged, v.(*fakeConn).id)
+		mu.Unlock()
+		return nil
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	cp := p.(*channelPool)
+	//连接1、2已空闲超过ValidateIdleThreshold，3、4刚放回
+	cp.Lock()
+	cp.freeConn[0].t = time.Now().Add(-time.Hour)
+	cp.freeConn[1].t = time.Now().Add(-time.Hour)
+	cp.Unlock()
+
+	if res := p.Maintain(); res.Pinged != 2 {
+		t.Errorf("Maintain pinged %d connections, want 2", res.Pinged)
+	}
+	mu.Lock()
+	if len(pinged) != 2 || pinged[0] != 1 || pinged[1] != 2 {
+		t.Errorf("pinged %v, want only the long-idle connections [1 2]", pinged)
+	}
+	mu.Unlock()
+	cp.Lock()
+	var ids []int32
+	for _, ic := range cp.freeConn {
+		ids = append(ids, ic.conn.(*fakeConn).id)
+	}
+	cp.Unlock()
+	if len(ids) != 4 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 || ids[3] != 4 {
+		t.Errorf("idle order after Maintain = %v, want [1 2 3 4]", ids)
+	}
+}
+
+func TestGracefulClose(t *testing.T) {
+	var created, graceful int32
+	poolConfig := newFakeConfig(4, 4, &created)
+	poolConfig.IdleTimeout = time.Minute
+	poolConfig.MaxIdle = 1
+	poolConfig.GracefulClose = func(v interface{}) error {
+		atomic.AddInt32(&graceful, 1)
+		return poolConfig.Close(v)
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	v, _ := p.Get()
+	p.Close(v)
+	if n := atomic.LoadInt32(&graceful); n != 0 {
+		t.Errorf("GracefulClose called %d times on a user Close, want 0", n)
+	}
+	if atomic.LoadInt32(&v.(*fakeConn).closed) != 1 {
+		t.Error("user Close did not close the connection")
+	}
+
+	cp := p.(*channelPool)
+	cp.Lock()
+	cp.freeConn[0].t = time.Now().Add(-time.Hour)
+	cp.Unlock()
+	//1个超过IdleTimeout，另1个超过MaxIdle
+	res := p.Maintain()
+	if res.Reaped != 1 || res.Trimmed != 1 {
+		t.Fatalf("Maintain = %+v, want 1 reaped and 1 trimmed", res)
+	}
+	if n := atomic.LoadInt32(&graceful); n != 2 {
+		t.Errorf("GracefulClose called %d times by Maintain, want 2", n)
+	}
+}
+
+func TestMaxIdleOnPut(t *testing.T) {
+	var created int32
+	var reasons []CloseReason
+	poolConfig := newFakeConfig(0, 0, &created)
+	poolConfig.MaxIdle = 2
+	poolConfig.OnConnClose = func(age time.Duration, reason CloseReason) {
+		reasons = append(reasons, reason)
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	var conns []interface{}
+	for i := 0; i < 4; i++ {
+		v, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, v)
+	}
+	p.Put(conns[0])
+	p.Put(conns[1])
+	p.Put(conns[2])
+	p.PutAll(conns[3:])
+	if st := p.Stats(); st.Idle != 2 || st.NumOpen != 2 {
+		t.Errorf("stats = %+v, want 2 idle connections and 2 open", st)
+	}
+	for _, v := range conns[2:] {
+		if atomic.LoadInt32(&v.(*fakeConn).closed) != 1 {
+			t.Errorf("connection %d returned past MaxIdle was not closed", v.(*fakeConn).id)
+		}
+	}
+	if len(reasons) != 2 || reasons[0] != CloseExcess || reasons[1] != CloseExcess {
+		t.Errorf("close reasons = %v, want 2 CloseExcess", reasons)
+	}
+}
+
+func TestSetStrategy(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(2, 2, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetStrategy(AlwaysNewConn)
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := v.(*fakeConn).id; id != 3 {
+		t.Errorf("Get returned connection %d, want a fresh one despite idle connections", id)
+	}
+	if st := p.Stats(); st.NumOpen != 2 || st.Idle != 1 || st.Closed != 1 {
+		t.Errorf("stats = %+v, want the oldest idle connection closed to make room", st)
+	}
+
+	p.SetStrategy(CachedOrNewConn)
+	p.Put(v)
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&created); n != 3 {
+		t.Errorf("created = %d after switching back, want reuse of an idle connection", n)
+	}
+	p.Release()
+}
+
+func TestAlwaysNewConnUnlimited(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 0, &created)
+	poolConfig.Strategy = AlwaysNewConn
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	for i := 0; i < 50; i++ {
+		v, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Put(v)
+	}
+	//每次Get都建立新连接，放回的连接在下一次Get时关闭，不会累积
+	if st := p.Stats(); st.Created != 50 || st.Idle != 1 || st.NumOpen != 1 || st.Closed != 49 {
+		t.Errorf("stats = %+v, want 50 created, 49 closed and 1 idle", st)
+	}
+}
+
+func TestStatsJSON(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(2, 4, &created)
+	poolConfig.Name = "db"
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := p.StatsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Stats
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := p.Stats(); got != want {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+	var raw map[string]interface{}
+	json.Unmarshal(data, &raw)
+	if raw["name"] != "db" || raw["in_use"] != float64(1) {
+		t.Errorf("JSON = %s, want snake_case fields", data)
+	}
+	if _, ok := raw["factory_latency"].(map[string]interface{})["avg_ns"]; !ok {
+		t.Errorf("JSON = %s, want factory latency in nanoseconds", data)
+	}
+	p.Release()
+}
+
+func TestValidateOnBorrow(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(2, 2, &created)
+	var checked []int32
+	poolConfig.ValidateOnBorrow = func(v interface{}) error {
+		id := v.(*fakeConn).id
+		checked = append(checked, id)
+		if id == 1 {
+			return errors.New("handshake failed")
+		}
+		return nil
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := v.(*fakeConn).id; id != 2 {
+		t.Errorf("Get returned connection %d, want 2", id)
+	}
+	if len(checked) != 2 {
+		t.Errorf("ValidateOnBorrow ran on %v, want both idle connections", checked)
+	}
+	if st := p.Stats(); st.NumOpen != 1 || st.Closed != 1 {
+		t.Errorf("stats = %+v, want the failing connection closed", st)
+	}
+	p.Put(v)
+	p.Release()
+}
+
+func TestDeadWaiterSkipped(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := p.(*channelPool)
+	v, _ := p.Get()
+
+	//模拟一个已离开却仍留在waitingQueue中的请求
+	dead := &waiter{req: make(chan idleConn, 1), gone: 1}
+	cp.Lock()
+	cp.waitingQueue = append(cp.waitingQueue, dead)
+	cp.Unlock()
+	if err := p.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	if len(dead.req) != 0 {
+		t.Error("connection was handed to the dead waiter")
+	}
+	if st := p.Stats(); st.Idle != 1 || st.WaitQueueLen != 0 {
+		t.Errorf("stats = %+v, want the connection idle and the dead waiter removed", st)
+	}
+
+	//已离开的请求之后有等待的请求时交给后者
+	v, _ = p.Get()
+	got := make(chan interface{}, 1)
+	cp.Lock()
+	cp.waitingQueue = append(cp.waitingQueue, dead)
+	cp.Unlock()
+	go func() {
+		w, _ := p.Get()
+		got <- w
+	}()
+	waitForWaiters(t, cp, 2)
+	p.Put(v)
+	if w := <-got; w != v {
+		t.Errorf("live waiter got %v, want the returned connection", w)
+	}
+	p.Release()
+}
+
+func TestOnFirstConnect(t *testing.T) {
+	var created, calls int32
+	var first interface{}
+	poolConfig := newFakeConfig(3, 5, &created)
+	poolConfig.OnFirstConnect = func(v interface{}) {
+		atomic.AddInt32(&calls, 1)
+		first = v
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Warm(1)
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("OnFirstConnect called %d times, want 1", n)
+	}
+	if first == nil || first.(*fakeConn).id != 1 {
+		t.Errorf("OnFirstConnect got %v, want the first connection", first)
+	}
+	p.Release()
+}
+
+func TestPutSync(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := p.(*channelPool)
+	v, _ := p.Get()
+
+	//由测试扮演等待的请求，控制取得连接的时间
+	req := make(chan idleConn, 1)
+	cp.Lock()
+	cp.waitingQueue = append(cp.waitingQueue, &waiter{req: req})
+	cp.Unlock()
+	done := make(chan error, 1)
+	go func() { done <- p.PutSync(v) }()
+
+	var ret idleConn
+	select {
+	case ret = <-req:
+	case <-time.After(time.Second):
+		t.Fatal("PutSync did not hand the connection to the waiter")
+	}
+	select {
+	case <-done:
+		t.Fatal("PutSync returned before the waiter took the connection")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if conn, _, _ := received(ret, true, nil); conn != v {
+		t.Fatalf("waiter got %v, want the put connection", conn)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("PutSync did not return after the waiter took the connection")
+	}
+
+	//没有等待的请求时同Put
+	if err := p.PutSync(v); err != nil {
+		t.Fatal(err)
+	}
+	if st := p.Stats(); st.Idle != 1 {
+		t.Errorf("stats = %+v, want the connection idle", st)
+	}
+	p.Release()
+}
+
+func TestWaitReady(t *testing.T) {
+	var created, calls int32
+	poolConfig := newFakeConfig(0, 2, &created)
+	factory := poolConfig.Factory
+	//前两次建立失败，模拟后端尚未启动
+	poolConfig.Factory = func() (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			return nil, errors.New("not up yet")
+		}
+		return factory()
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady = %v, want nil once the factory succeeds", err)
+	}
+	if st := p.Stats(); st.Idle != 1 || st.InUse != 0 {
+		t.Errorf("stats = %+v, want one idle connection and none taken", st)
+	}
+
+	//连接都被占用且已达MaxCap时等到ctx结束
+	a, _ := p.Get()
+	b, _ := p.Get()
+	short, cancelShort := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancelShort()
+	if err := p.WaitReady(short); err != context.DeadlineExceeded {
+		t.Errorf("WaitReady on a full pool = %v, want %v", err, context.DeadlineExceeded)
+	}
+	p.Put(a)
+	p.Put(b)
+	p.Release()
+}
+
+func TestWaitReadyPutCoalesce(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 4, &created)
+	poolConfig.PutCoalesceQueue = 4
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	//建立的连接不经过放回队列，回传时已是空闲连接，也不会多建立
+	if st := p.Stats(); st.Idle != 1 || st.Created != 1 {
+		t.Errorf("stats = %+v, want exactly one idle connection", st)
+	}
+
+	//已放回但后台协程还未处理完的连接算作空闲连接，不会因此多建立连接
+	v, _ := p.Get()
+	p.Put(v)
+	short, cancelShort := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancelShort()
+	if err := p.WaitReady(short); err != nil {
+		t.Errorf("WaitReady with a queued connection = %v, want nil", err)
+	}
+	if n := atomic.LoadInt32(&created); n != 1 {
+		t.Errorf("factory called %d times, want 1", n)
+	}
+}
+
+func TestOnReuseCount(t *testing.T) {
+	var created int32
+	var reported []int
+	poolConfig := newFakeConfig(0, 1, &created)
+	poolConfig.OnReuseCount = func(uses int) { reported = append(reported, uses) }
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	for i := 0; i < 3; i++ {
+		if v, err = p.Get(); err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 {
+			p.Put(v)
+		}
+	}
+	if err := p.Close(v); err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || reported[0] != 3 {
+		t.Errorf("OnReuseCount calls = %v, want [3]", reported)
+	}
+	if rc := p.Stats().ReuseCount; rc != (ReuseCount{Count: 1, Avg: 3, Max: 3}) {
+		t.Errorf("ReuseCount = %+v, want one connection used 3 times", rc)
+	}
+	p.Release()
+}
+
+func TestOnReuseCountQuarantine(t *testing.T) {
+	var created int32
+	var reported []int
+	poolConfig := newFakeConfig(0, 1, &created)
+	poolConfig.OnReuseCount = func(uses int) { reported = append(reported, uses) }
+	poolConfig.Validate = func(v interface{}) bool { return false }
+	poolConfig.Quarantine = func(v interface{}, reason string) {}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	v, _ := p.Get()
+	p.Put(v)
+	//取出时未通过Validate而交给Quarantine，也要结束它的记录
+	w, _ := p.Get()
+	if w == v {
+		t.Fatal("Get returned the invalid connection")
+	}
+	if len(reported) != 1 || reported[0] != 1 {
+		t.Errorf("OnReuseCount calls = %v, want [1] for the quarantined connection", reported)
+	}
+	cp := p.(*channelPool)
+	cp.Lock()
+	leaked := cp.infoLocked(v) != nil
+	cp.Unlock()
+	if leaked {
+		t.Error("the quarantined connection is still recorded")
+	}
+}
+
+func TestOnReuseCountUnhashableConn(t *testing.T) {
+	poolConfig := &Config{
+		MaxCap:  1,
+		Factory: func() (interface{}, error) { return []byte("conn"), nil },
+		Close:   func(v interface{}) error { return nil },
+	}
+	var calls int
+	poolConfig.OnReuseCount = func(uses int) { calls++ }
+	poolConfig.OnReturnDuration = func(d time.Duration) { calls++ }
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	//slice不能作为map的key，不记录也不panic
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	v, _ = p.Get()
+	p.Close(v)
+	if calls != 0 {
+		t.Errorf("callbacks called %d times for an unhashable connection, want 0", calls)
+	}
+}
+
+// structConn 可比较但其中的interface字段装着slice，作为map的key时hash会panic
+type structConn struct {
+	id   int
+	meta interface{}
+}
+
+func TestUnhashableStructConn(t *testing.T) {
+	var closed int32
+	for _, track := range []bool{false, true} {
+		poolConfig := &Config{
+			MaxCap:           1,
+			Factory:          func() (interface{}, error) { return structConn{id: 1, meta: []byte("x")}, nil },
+			Close:            func(v interface{}) error { atomic.AddInt32(&closed, 1); return nil },
+			MaxLifetime:      time.Hour,
+			TrackConnections: track,
+		}
+		p, err := NewPool(poolConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			v, err := p.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Put(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ages := p.ConnectionAges(); len(ages) != 1 || !ages[0].Created.IsZero() {
+			t.Errorf("ConnectionAges = %+v, want one idle connection without a creation time", ages)
+		}
+		p.Release()
+	}
+	if n := atomic.LoadInt32(&closed); n != 2 {
+		t.Errorf("closed %d connections, want 2", n)
+	}
+}
+
+func TestNoTrackingByDefault(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	if cp := p.(*channelPool); cp.conns != nil {
+		t.Error("connections are recorded although nothing uses the records")
+	}
+}
+
+func TestReleaseErrors(t *testing.T) {
+	var created, calls int32
+	errClose := errors.New("connection reset")
+	poolConfig := newFakeConfig(3, 3, &created)
+	//第二次关闭失败
+	poolConfig.Close = func(v interface{}) error {
+		if atomic.AddInt32(&calls, 1) == 2 {
+			return errClose
+		}
+		return nil
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := p.ReleaseErrors(); errs != nil {
+		t.Errorf("ReleaseErrors before Release = %v, want nil", errs)
+	}
+	p.Release()
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("close called %d times, want all 3 connections attempted", n)
+	}
+	if errs := p.ReleaseErrors(); len(errs) != 1 || errs[0] != errClose {
+		t.Errorf("ReleaseErrors = %v, want [%v]", errs, errClose)
+	}
+}
+
+func TestOnRelease(t *testing.T) {
+	var created int32
+	var trace []string
+	errBye := errors.New("goodbye failed")
+	poolConfig := newFakeConfig(2, 2, &created)
+	closeConn := poolConfig.Close
+	poolConfig.Close = func(v interface{}) error {
+		trace = append(trace, fmt.Sprintf("close %d", v.(*fakeConn).id))
+		return closeConn(v)
+	}
+	//第一个连接的道别失败，仍要关闭
+	poolConfig.OnRelease = func(v interface{}) error {
+		trace = append(trace, fmt.Sprintf("release %d", v.(*fakeConn).id))
+		if len(trace) == 1 {
+			return errBye
+		}
+		return nil
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release()
+	if got, want := strings.Join(trace, ", "), "release 1, close 1, release 2, close 2"; got != want {
+		t.Errorf("trace = %s, want %s", got, want)
+	}
+	if errs := p.ReleaseErrors(); len(errs) != 1 || errs[0] != errBye {
+		t.Errorf("ReleaseErrors = %v, want [%v]", errs, errBye)
+	}
+}
+
+func TestCapacityAvailable(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(0, 2, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	a, _ := p.Get()
+	b, _ := p.Get()
+	select {
+	case <-p.CapacityAvailable():
+		t.Fatal("signal received while the pool is saturated")
+	default:
+	}
+
+	//两次放回的信号合并为一个
+	p.Put(a)
+	p.Put(b)
+	select {
+	case <-p.CapacityAvailable():
+	case <-time.After(time.Second):
+		t.Fatal("no signal after freeing the pool")
+	}
+	select {
+	case <-p.CapacityAvailable():
+		t.Error("signals were not coalesced")
+	default:
+	}
+
+	//Close空出名额也送出信号
+	v, _ := p.Get()
+	p.Close(v)
+	select {
+	case <-p.CapacityAvailable():
+	case <-time.After(time.Second):
+		t.Fatal("no signal after closing a connection")
+	}
+}
+
+func TestGetOnClosedPool(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(1, 1, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release()
+	if v, err := p.Get(); v != nil || err != ErrPoolClosed {
+		t.Errorf("Get on a closed pool = (%v, %v), want (nil, ErrPoolClosed)", v, err)
+	}
+	//nil错误只表示连接数已满，关闭的pool仍回传错误
+	if v, err := p.GetTry(); v != nil || err != ErrPoolClosed {
+		t.Errorf("GetTry on a closed pool = (%v, %v), want (nil, ErrPoolClosed)", v, err)
+	}
+}
+
+func TestCloseWrongType(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 1, &created)
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	if _, err := p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	//close对*fakeConn做类型断言，传入其它类型会panic
+	if err := p.Close("not a connection"); err != ErrCloseFailed {
+		t.Errorf("Close of a wrong-typed connection = %v, want %v", err, ErrCloseFailed)
+	}
+	if n := p.Stats().NumOpen; n != 0 {
+		t.Errorf("NumOpen = %d after a failed close, want 0", n)
+	}
+}
+
+func TestGetBatch(t *testing.T) {
+	var created int32
+	p, err := NewPool(newFakeConfig(0, 3, &created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	conns, err := p.GetBatch(5, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conns) != 3 {
+		t.Errorf("GetBatch(5) returned %d connections, want 3", len(conns))
+	}
+	//放弃等待的请求不留在队列中
+	if st := p.Stats(); st.InUse != 3 || st.WaitQueueLen != 0 {
+		t.Errorf("stats = %+v, want 3 in use and no waiters left", st)
+	}
+	if err := p.PutAll(conns); err != nil {
+		t.Fatal(err)
+	}
+
+	//pool关闭时回传错误
+	p.Release()
+	if conns, err := p.GetBatch(2, 50*time.Millisecond); conns != nil || err != ErrPoolClosed {
+		t.Errorf("GetBatch on a closed pool = (%v, %v), want (nil, ErrPoolClosed)", conns, err)
+	}
+}
+
+func TestGetBatchPartial(t *testing.T) {
+	var created, calls int32
+	poolConfig := newFakeConfig(0, 5, &created)
+	factory := poolConfig.Factory
+	//第三次建立失败
+	poolConfig.Factory = func() (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 3 {
+			return nil, errors.New("backend down")
+		}
+		return factory()
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	if conns, err := p.GetBatch(-1, time.Second); len(conns) != 0 || err != nil {
+		t.Errorf("GetBatch(-1) = (%v, %v), want an empty batch", conns, err)
+	}
+	//建立失败时回传已取得的连接而不回传错误
+	conns, err := p.GetBatch(4, time.Second)
+	if len(conns) != 2 || err != nil {
+		t.Errorf("GetBatch(4) with a failing factory = (%d connections, %v), want (2, nil)", len(conns), err)
+	}
+	if st := p.Stats(); st.InUse != len(conns) {
+		t.Errorf("InUse = %d, want the %d returned connections", st.InUse, len(conns))
+	}
+}
+
+func TestValidateAbandoned(t *testing.T) {
+	for _, check := range []bool{false, true} {
+		var created int32
+		poolConfig := newFakeConfig(1, 1, &created)
+		poolConfig.ValidateAbandoned = check
+		poolConfig.Validate = func(v interface{}) bool { return false }
+		p, err := NewPool(poolConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cp := p.(*channelPool)
+		v, _ := p.Get()
+
+		//由测试扮演等待的请求，连接交出后才放弃等待
+		req := make(chan idleConn, 1)
+		cp.Lock()
+		cp.waitingQueue = append(cp.waitingQueue, &waiter{req: req})
+		cp.Unlock()
+		if err := p.Put(v); err != nil {
+			t.Fatal(err)
+		}
+		cp.abandonWaiter(req)
+
+		closed := atomic.LoadInt32(&v.(*fakeConn).closed) == 1
+		st := p.Stats()
+		if check && (!closed || st.NumOpen != 0) {
+			t.Errorf("ValidateAbandoned: closed = %v, NumOpen = %d, want the failing connection closed", closed, st.NumOpen)
+		}
+		if !check && (closed || st.Idle != 1) {
+			t.Errorf("default: closed = %v, Idle = %d, want the connection returned to the pool", closed, st.Idle)
+		}
+		p.Release()
+	}
+}
+
+func TestMaxWaiters(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 1, &created)
+	poolConfig.MaxWaiters = 2
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := p.Get()
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := p.Get()
+			errs <- err
+		}()
+	}
+	waitForWaiters(t, p.(*channelPool), 2)
+
+	//等待队列已满，之后的Get立即被拒绝，队列不再增长
+	for i := 0; i < 100; i++ {
+		if _, err := p.Get(); err != ErrTooManyWaiters {
+			t.Fatalf("Get #%d past MaxWaiters = %v, want %v", i, err, ErrTooManyWaiters)
+		}
+	}
+	if st := p.Stats(); st.WaitRejected != 100 || st.WaitQueueLen != 2 {
+		t.Errorf("WaitRejected = %d, WaitQueueLen = %d, want 100, 2", st.WaitRejected, st.WaitQueueLen)
+	}
+	p.Put(v)
+	p.Release()
+	for i := 0; i < 2; i++ {
+		<-errs
+	}
+}
+
+func TestIdleLess(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 3, &created)
+	//id较大的连接较晚建立，优先取出
+	poolConfig.IdleLess = func(a, b interface{}) bool { return a.(*fakeConn).id > b.(*fakeConn).id }
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	conns := make([]interface{}, 3)
+	for i := range conns {
+		if conns[i], err = p.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//放回顺序与建立顺序不同，未设定IdleLess时会先取出id 1
+	p.Put(conns[0])
+	p.Put(conns[2])
+	p.Put(conns[1])
+	for _, want := range []int32{3, 2, 1} {
+		v, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := v.(*fakeConn).id; id != want {
+			t.Errorf("Get returned connection %d, want %d", id, want)
+		}
+	}
+}
+
+func TestOnReturnDuration(t *testing.T) {
+	var created int32
+	var reported []time.Duration
+	poolConfig := newFakeConfig(0, 1, &created)
+	poolConfig.OnReturnDuration = func(d time.Duration) { reported = append(reported, d) }
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := p.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || reported[0] < 30*time.Millisecond || reported[0] > time.Second {
+		t.Fatalf("OnReturnDuration calls = %v, want one of about 30ms", reported)
+	}
+	if hd := p.Stats().InUseDuration; hd.Count != 1 || hd.Avg != reported[0] || hd.Max != reported[0] {
+		t.Errorf("InUseDuration = %+v, want one return of %v", hd, reported[0])
+	}
+}
+
+func TestOnReturnDurationHandoff(t *testing.T) {
+	const workers, rounds = 8, 500
+	var created, reported, short int32
+	poolConfig := newFakeConfig(0, 1, &created)
+	poolConfig.OnReturnDuration = func(d time.Duration) {
+		atomic.AddInt32(&reported, 1)
+		if d < time.Microsecond {
+			atomic.AddInt32(&short, 1)
+		}
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	//MaxCap为1时每次Put都交给等待中的请求，放回的记录不可被新的持有者的取出记录取代
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				v, err := p.Get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				start := time.Now()
+				for time.Since(start) < time.Microsecond {
+				}
+				p.Put(v)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&reported); n != workers*rounds {
+		t.Errorf("OnReturnDuration called %d times, want %d", n, workers*rounds)
+	}
+	if n := atomic.LoadInt32(&short); n != 0 {
+		t.Errorf("%d durations were shorter than the hold time", n)
+	}
+	if hd := p.Stats().InUseDuration; hd.Count != workers*rounds {
+		t.Errorf("InUseDuration.Count = %d, want %d", hd.Count, workers*rounds)
+	}
+}
+
+func TestReleaseTwice(t *testing.T) {
+	var created int32
+	closes := make(map[int32]int)
+	var mu sync.Mutex
+	poolConfig := newFakeConfig(3, 3, &created)
+	poolConfig.Close = func(v interface{}) error {
+		mu.Lock()
+		closes[v.(*fakeConn).id]++
+		mu.Unlock()
+		return nil
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release()
+	p.Release()
+	if len(closes) != 3 {
+		t.Errorf("closed %d connections, want 3", len(closes))
+	}
+	for id, n := range closes {
+		if n != 1 {
+			t.Errorf("connection %d closed %d times, want once", id, n)
+		}
+	}
+	if st := p.Stats(); st.NumOpen != 0 || st.Closed != 3 {
+		t.Errorf("stats = %+v, want NumOpen 0 and 3 closed", st)
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(3, 3, &created)
+	poolConfig.IdleTimeout = time.Minute
+	poolConfig.MaxLifetime = time.Minute
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	cp := p.(*channelPool)
+	//连接1建立已超过MaxLifetime但刚放回，连接2闲置已超过IdleTimeout
+	cp.Lock()
+	cp.infoLocked(cp.freeConn[0].conn).born = time.Now().Add(-time.Hour)
+	cp.freeConn[1].t = time.Now().Add(-time.Hour)
+	cp.Unlock()
+
+	res := p.Maintain()
+	if res.Expired != 1 || res.Reaped != 1 {
+		t.Errorf("Maintain = %+v, want 1 expired and 1 reaped", res)
+	}
+	if st := p.Stats(); st.ClosedLifetime != 1 || st.ClosedIdle != 1 || st.NumOpen != 1 {
+		t.Errorf("stats = %+v, want 1 closed by lifetime, 1 by idle, 1 open", st)
+	}
+
+	//放回时已超过MaxLifetime的连接直接关闭
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Lock()
+	cp.infoLocked(v).born = time.Now().Add(-time.Hour)
+	cp.Unlock()
+	if err := p.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&v.(*fakeConn).closed) != 1 {
+		t.Error("expired connection returned by Put was not closed")
+	}
+
+	//Get跳过闲置中已超过MaxLifetime的连接
+	v, err = p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(v)
+	cp.Lock()
+	cp.infoLocked(v).born = time.Now().Add(-time.Hour)
+	cp.Unlock()
+	w, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w == v {
+		t.Error("Get returned an expired connection")
+	}
+	if st := p.Stats(); st.ClosedLifetime != 3 || st.ClosedIdle != 1 || st.NumOpen != 1 {
+		t.Errorf("stats = %+v, want 3 closed by lifetime, 1 by idle, 1 open", st)
+	}
+	p.Put(w)
+}
+
+func TestConnectionAges(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 3, &created)
+	poolConfig.TrackConnections = true
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	var conns []interface{}
+	for i := 0; i < 3; i++ {
+		v, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, v)
+		time.Sleep(5 * time.Millisecond)
+	}
+	p.Put(conns[1])
+
+	ages := p.ConnectionAges()
+	if len(ages) != 3 {
+		t.Fatalf("ConnectionAges returned %d entries, want 3", len(ages))
+	}
+	for i := 1; i < len(ages); i++ {
+		if time.Since(ages[i].Created) > time.Since(ages[i-1].Created) {
+			t.Errorf("age %d is older than age %d, want oldest first", i, i-1)
+		}
+	}
+	if !ages[0].InUse || ages[1].InUse || !ages[2].InUse {
+		t.Errorf("InUse = %v %v %v, want only the second connection idle", ages[0].InUse, ages[1].InUse, ages[2].InUse)
+	}
+	if ages[1].LastUsed.IsZero() || ages[1].Idle < 0 {
+		t.Errorf("idle entry = %+v, want LastUsed set", ages[1])
+	}
+	p.Put(conns[0])
+	p.Put(conns[2])
+}
+
+func TestConnectionAgesParked(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(2, 2, &created)
+	poolConfig.TrackConnections = true
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	if n := p.Park(func(interface{}) bool { return true }); n != 2 {
+		t.Fatalf("Park = %d, want 2", n)
+	}
+	ages := p.ConnectionAges()
+	if len(ages) != 2 {
+		t.Fatalf("ConnectionAges returned %d entries, want 2", len(ages))
+	}
+	for i, a := range ages {
+		if a.InUse || !a.Parked || a.Created.IsZero() {
+			t.Errorf("entry %d = %+v, want a parked connection with its creation time", i, a)
+		}
+	}
+	p.Unpark()
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ages = p.ConnectionAges()
+	inUse := 0
+	for _, a := range ages {
+		if a.InUse {
+			inUse++
+		}
+		if a.Parked {
+			t.Errorf("entry %+v still parked after Unpark", a)
+		}
+	}
+	if len(ages) != 2 || inUse != 1 {
+		t.Errorf("ConnectionAges = %+v, want 1 in use and 1 idle", ages)
+	}
+	p.Put(v)
+	for _, a := range p.ConnectionAges() {
+		if a.InUse {
+			t.Errorf("entry %+v in use after Put", a)
+		}
+	}
+}
+
+func TestOnConnClose(t *testing.T) {
+	type closed struct {
+		age    time.Duration
+		reason CloseReason
+	}
+	var mu sync.Mutex
+	var got []closed
+	var created int32
+	poolConfig := newFakeConfig(2, 2, &created)
+	poolConfig.OnConnClose = func(age time.Duration, reason CloseReason) {
+		mu.Lock()
+		got = append(got, closed{age, reason})
+		mu.Unlock()
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	p.Close(v)
+	p.Release()
+
+	mu.Lock()
+	if n := len(got); n != 2 {
+		mu.Unlock()
+		t.Fatalf("OnConnClose called %d times, want 2", n)
+	}
+	if got[0].reason != CloseUser || got[1].reason != CloseRelease {
+		t.Errorf("reasons = %v, %v, want CloseUser, CloseRelease", got[0].reason, got[1].reason)
+	}
+	for _, c := range got {
+		if c.age < 10*time.Millisecond || c.age > time.Minute {
+			t.Errorf("age = %v, want the time since the connection was created", c.age)
+		}
+	}
+	got = nil
+	mu.Unlock()
+
+	//pool自行关闭已取出的连接时不算CloseUser
+	reasons := func() []CloseReason {
+		mu.Lock()
+		defer mu.Unlock()
+		r := make([]CloseReason, len(got))
+		for i, c := range got {
+			r[i] = c.reason
+		}
+		got = nil
+		return r
+	}
+	var reject int32
+	poolConfig.InitialCap = 0
+	poolConfig.ValidateAbandoned = true
+	poolConfig.Validate = func(interface{}) bool { return atomic.LoadInt32(&reject) == 0 }
+	gate := make(chan struct{})
+	close(gate)
+	factory := poolConfig.Factory
+	poolConfig.Factory = func() (interface{}, error) {
+		<-gate
+		return factory()
+	}
+	p, err = NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	cp := p.(*channelPool)
+	reasons()
+
+	//TryN检查未通过
+	v, _ = p.Get()
+	p.Put(v)
+	v, err = p.TryN(func(interface{}) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := reasons(); len(r) != 1 || r[0] != CloseReset {
+		t.Errorf("TryN reasons = %v, want [CloseReset]", r)
+	}
+
+	//放弃等待后ValidateAbandoned检查未通过
+	req := make(chan idleConn, 1)
+	cp.Lock()
+	cp.waitingQueue = append(cp.waitingQueue, &waiter{req: req})
+	cp.Unlock()
+	atomic.StoreInt32(&reject, 1)
+	p.Put(v)
+	cp.abandonWaiter(req)
+	atomic.StoreInt32(&reject, 0)
+	if r := reasons(); len(r) != 1 || r[0] != CloseReset {
+		t.Errorf("abandoned reasons = %v, want [CloseReset]", r)
+	}
+
+	//调用者放弃后才建立成功的连接
+	gate = make(chan struct{})
+	cancel := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := p.GetWithCancel(cancel)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(cancel)
+	if err := <-done; err != ErrCanceled {
+		t.Fatalf("GetWithCancel = %v, want ErrCanceled", err)
+	}
+	close(gate)
+	deadline := time.Now().Add(time.Second)
+	var r []CloseReason
+	for len(r) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		r = reasons()
+	}
+	if len(r) != 1 || r[0] != CloseExcess {
+		t.Errorf("canceled create reasons = %v, want [CloseExcess]", r)
+	}
+}
+
+func TestStrictLifetime(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 2, &created)
+	poolConfig.MaxLifetime = 20 * time.Millisecond
+	poolConfig.StrictLifetime = true
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	v, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(v); err != nil {
+		t.Fatalf("Put of a fresh connection = %v, want nil", err)
+	}
+	v, err = p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := p.Put(v); err != ErrConnExpired {
+		t.Errorf("Put of an expired connection = %v, want ErrConnExpired", err)
+	}
+	if atomic.LoadInt32(&v.(*fakeConn).closed) != 1 {
+		t.Error("expired connection was not closed")
+	}
+	if st := p.Stats(); st.NumOpen != 0 || st.ClosedLifetime != 1 {
+		t.Errorf("stats = %+v, want no open connections and 1 closed by lifetime", st)
+	}
+}
+
+func TestEarlyRecycleFraction(t *testing.T) {
+	var created int32
+	poolConfig := newFakeConfig(0, 1, &created)
+	poolConfig.MaxLifetime = time.Hour
+	poolConfig.EarlyRecycleFraction = 0.5
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+	cp := p.(*channelPool)
+
+	//以存活时间为age的连接Get n次，回传提早回收的次数
+	recycled := func(age time.Duration, n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			v, err := p.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cp.Lock()
+			cp.infoLocked(v).born = time.Now().Add(-age)
+			cp.Unlock()
+			p.Put(v)
+			w, err := p.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w != v {
+				count++
+			}
+			p.Put(w)
+		}
+		return count
+	}
+
+	if n := recycled(20*time.Minute, 200); n != 0 {
+		t.Errorf("recycled %d of 200 connections before the early-recycle point, want 0", n)
+	}
+	//存活45分钟位于30分钟到60分钟的中点，回收机率约为一半
+	if n := recycled(45*time.Minute, 1000); n < 350 || n > 650 {
+		t.Errorf("recycled %d of 1000 connections at 75%% of MaxLifetime, want about 500", n)
+	}
+	if st := p.Stats(); st.ClosedLifetime != st.Closed {
+		t.Errorf("stats = %+v, want every close counted as a lifetime close", st)
+	}
+}
+
+func TestReleaseKeepingOnRelease(t *testing.T) {
+	var created int32
+	var released int32
+	poolConfig := newFakeConfig(4, 4, &created)
+	poolConfig.OnRelease = func(v interface{}) error {
+		atomic.AddInt32(&released, 1)
+		return errors.New("goodbye failed")
+	}
+	poolConfig.Close = func(v interface{}) error {
+		if v.(*fakeConn).id == 4 {
+			return errors.New("close failed")
+		}
+		return nil
+	}
+	p, err := NewPool(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ReleaseKeeping(2, time.Hour)
+	defer p.Release()
+	if n := atomic.LoadInt32(&released); n != 2 {
+		t.Errorf("OnRelease called %d times, want 2 for the closed extra connections", n)
+	}
+	//两次OnRelease错误及连接4的close错误
+	if errs := p.ReleaseErrors(); len(errs) != 3 {
+		t.Errorf("ReleaseErrors = %v, want 3 errors", errs)
+	}
+}
+
 // func TestPool_Get(t *testing.T) {
 // 	pool, err := NewGenericPool(0, 5, time.Minute*10, func() (Poolable, error) {
 // 		time.Sleep(time.Second)